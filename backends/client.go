@@ -1,5 +1,6 @@
 // 导入需要的包
 import (
+	"context" // 用于取消监听
 	"fmt" // 用于格式化输出
 	"strings" // 用于处理字符串
 
@@ -12,7 +13,22 @@ import (
 // 定义StoreClient接口，包含获取值和监听前缀的方法
 type StoreClient interface {
 	GetValues(keys []string) (map[string]string, error) // 获取指定键的值
-	WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) // 监听指定前缀的键值变化
+	WatchPrefix(ctx context.Context, prefix string, keys []string, waitIndex uint64) (uint64, error) // 监听指定前缀的键值变化，ctx 取消时返回
+}
+
+// ErrKeyNotFound 表示后端中不存在请求的键。GetValues 遇到不存在的键时仍返回其余键的值，
+// 同时返回包装了 ErrKeyNotFound 的错误，调用方用 errors.Is 判断
+var ErrKeyNotFound = nacos.ErrKeyNotFound
+
+// Closer 由持有连接等资源的 StoreClient 实现。重新加载配置后不再使用的客户端用 Close 释放
+type Closer interface {
+	Close()
+}
+
+// Unwatcher 由为键注册监听的 StoreClient 实现。waitIndex 为 0 的 WatchPrefix 每注册一次 keys，
+// 不再需要时就用同样的 keys 调用一次 Unwatch，所有注册都释放后取消后端的监听
+type Unwatcher interface {
+	Unwatch(keys []string)
 }
 
 // New函数用于创建一个新的StoreClient实例
@@ -1,10 +1,13 @@
 package nacos
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/Risingtao/nacos-confd/log"
 	"github.com/nacos-group/nacos-sdk-go/v2/clients"
@@ -19,6 +22,9 @@ import (
 // Replacer 用于处理键格式
 var replacer = strings.NewReplacer("/", ".")
 
+// ErrKeyNotFound 表示 nacos 中不存在请求的配置
+var ErrKeyNotFound = errors.New("键不存在")
+
 // Client 结构体，包含配置客户端和命名客户端
 type Client struct {
 	configClient config_client.IConfigClient
@@ -27,8 +33,13 @@ type Client struct {
 	namespace    string
 	accessKey    string
 	secretKey    string
-	channel      chan int
-	count        int
+	mu           sync.Mutex
+	index        uint64            // 每次配置或服务变化时递增
+	versions     map[string]uint64 // 各 dataId 和服务最近一次变化时的 index
+	changes      chan struct{}     // 下一次变化时关闭
+	listenMu     sync.Mutex
+	listening    map[string]int                // 已注册监听的 dataId 和服务，值为注册的次数
+	subscribes   map[string]*vo.SubscribeParam // 服务订阅的参数，取消订阅时需要同一个回调
 }
 
 // NewNacosClient 初始化 Nacos 客户端
@@ -107,16 +118,21 @@ func NewNacosClient(nodes []string, group string, config constant.ClientConfig)
 		namespace:    config.NamespaceId,
 		accessKey:    config.AccessKey,
 		secretKey:    config.SecretKey,
-		channel:      make(chan int, 10),
-		count:        0,
+		index:        1,
+		versions:     make(map[string]uint64),
+		changes:      make(chan struct{}),
+		listening:    make(map[string]int),
+		subscribes:   make(map[string]*vo.SubscribeParam),
 	}
 
 	return client, nil
 }
 
-// GetValues 获取指定键的值
+// GetValues 获取指定键的值。不存在的配置不包含在结果中，此时仍返回其余键的值，
+// 同时返回包装了 ErrKeyNotFound 的错误
 func (client *Client) GetValues(keys []string) (map[string]string, error) {
 	vars := make(map[string]string)
+	var missing []string
 	for _, key := range keys {
 		k := strings.TrimPrefix(key, "/")
 		k = replacer.Replace(k)
@@ -142,71 +158,172 @@ func (client *Client) GetValues(keys []string) (map[string]string, error) {
 				log.Error(fmt.Sprintf("获取配置失败,key: %s, 错误: %v", key, err))
 				return nil, err
 			}
+			// nacos 不允许发布内容为空的配置，SDK 对不存在的配置返回空内容
+			if resp == "" {
+				log.Debug(fmt.Sprintf("配置不存在,key: %s", key))
+				missing = append(missing, key)
+				continue
+			}
 			vars[key] = resp
 		}
 	}
+	if len(missing) > 0 {
+		return vars, fmt.Errorf("%w: %s", ErrKeyNotFound, strings.Join(missing, ", "))
+	}
 	return vars, nil
 }
 
-// WatchPrefix 订阅服务和监听配置
-func (client *Client) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+// WatchPrefix 订阅服务和监听配置。waitIndex 为 0 时注册 keys 并立即返回当前的 index，
+// 之后的调用等待 keys 中任一配置或服务在 waitIndex 之后发生变化，返回变化时的 index；
+// ctx 取消时返回 ctx 的错误
+func (client *Client) WatchPrefix(ctx context.Context, prefix string, keys []string, waitIndex uint64) (uint64, error) {
+	names := make([]string, 0, len(keys))
+	for _, key := range keys {
+		names = append(names, replacer.Replace(strings.TrimPrefix(key, "/")))
+	}
+
 	if waitIndex == 0 {
-		client.count++
-		for _, key := range keys {
-			k := strings.TrimPrefix(key, "/")
-			k = replacer.Replace(k)
-
-			// 如果键以 "naming." 开头，则订阅服务
-			if strings.HasPrefix(k, "naming.") {
-				err := client.namingClient.Subscribe(&vo.SubscribeParam{
-					ServiceName: k,
-					GroupName:   client.group,
-					SubscribeCallback: func(services []model.Instance, err error) {
-						if err != nil {
-							log.Error(fmt.Sprintf("订阅服务失败: %v\n", err))
-							return
-						}
-
-						log.Info(fmt.Sprintf("订阅回调 - 服务实例: %s", util.ToJsonString(services)))
-
-						for i := 0; i < client.count; i++ {
-							client.channel <- 1
-						}
-					},
-				})
-				if err != nil {
-					log.Error(fmt.Sprintf("订阅服务失败: %s, 错误: %v", k, err))
-					return 0, err
-				}
-			} else {
-				// 否则监听配置
-				err := client.configClient.ListenConfig(vo.ConfigParam{
-					DataId: k,
-					Group:  client.group,
-					OnChange: func(namespace, group, dataId, data string) {
-
-						log.Info(fmt.Sprintf("配置变更: namespace: %s, dataId: %s, group: %s", namespace, dataId, group))
-
-						for i := 0; i < client.count; i++ {
-							client.channel <- 1
-						}
-					},
-				})
+		// 先取 index 再注册，注册期间发生的变化会让下一次调用立即返回
+		client.mu.Lock()
+		index := client.index
+		client.mu.Unlock()
+		for i, k := range names {
+			if err := client.listen(k); err != nil {
+				client.unlisten(names[:i])
+				return 0, err
+			}
+		}
+		return index, nil
+	}
+
+	for {
+		client.mu.Lock()
+		var latest uint64
+		for _, k := range names {
+			if v := client.versions[k]; v > waitIndex && v > latest {
+				latest = v
+			}
+		}
+		changes := client.changes
+		client.mu.Unlock()
+		if latest > 0 {
+			return latest, nil
+		}
+
+		select {
+		case <-changes:
+		case <-ctx.Done():
+			log.Debug("收到停止信号，停止等待变更。")
+			return waitIndex, ctx.Err()
+		}
+	}
+}
+
+// Close 关闭与 nacos 的连接，之后客户端不能再使用
+func (client *Client) Close() {
+	client.configClient.CloseClient()
+	client.namingClient.CloseClient()
+}
+
+// Unwatch 释放 WatchPrefix 为 keys 注册的监听
+func (client *Client) Unwatch(keys []string) {
+	names := make([]string, 0, len(keys))
+	for _, key := range keys {
+		names = append(names, replacer.Replace(strings.TrimPrefix(key, "/")))
+	}
+	client.unlisten(names)
+}
+
+// listen 为配置或服务注册监听。每个键只向 nacos 注册一次，之后只增加注册次数
+func (client *Client) listen(k string) error {
+	client.listenMu.Lock()
+	defer client.listenMu.Unlock()
+	if client.listening[k] > 0 {
+		client.listening[k]++
+		return nil
+	}
+
+	var err error
+	// 如果键以 "naming." 开头，则订阅服务
+	if strings.HasPrefix(k, "naming.") {
+		param := &vo.SubscribeParam{
+			ServiceName: k,
+			GroupName:   client.group,
+			SubscribeCallback: func(services []model.Instance, err error) {
 				if err != nil {
-					log.Error(fmt.Sprintf("监听配置失败: %s, 错误: %v", k, err))
-					return 0, err
+					log.Error(fmt.Sprintf("订阅服务失败: %v\n", err))
+					return
 				}
-			}
+
+				log.Info(fmt.Sprintf("订阅回调 - 服务实例: %s", util.ToJsonString(services)))
+				client.changed(k)
+			},
+		}
+		err = client.namingClient.Subscribe(param)
+		if err != nil {
+			log.Error(fmt.Sprintf("订阅服务失败: %s, 错误: %v", k, err))
+		} else {
+			client.subscribes[k] = param
 		}
-		return 1, nil
+	} else {
+		// 否则监听配置
+		err = client.configClient.ListenConfig(vo.ConfigParam{
+			DataId: k,
+			Group:  client.group,
+			OnChange: func(namespace, group, dataId, data string) {
+
+				log.Info(fmt.Sprintf("配置变更: namespace: %s, dataId: %s, group: %s", namespace, dataId, group))
+				client.changed(k)
+			},
+		})
+		if err != nil {
+			log.Error(fmt.Sprintf("监听配置失败: %s, 错误: %v", k, err))
+		}
+	}
+	if err == nil {
+		client.listening[k] = 1
 	}
+	return err
+}
 
-	select {
-	case <-client.channel:
-		// 通道有消息时的日志
-		return waitIndex, nil
-	case <-stopChan:
-		log.Info("收到停止信号，停止监听。")
-		return waitIndex, nil
+// unlisten 减少各键的注册次数，减到 0 时取消 nacos 中的监听或订阅
+func (client *Client) unlisten(names []string) {
+	client.listenMu.Lock()
+	defer client.listenMu.Unlock()
+	for _, k := range names {
+		if client.listening[k] == 0 {
+			continue
+		}
+		client.listening[k]--
+		if client.listening[k] > 0 {
+			continue
+		}
+		delete(client.listening, k)
+
+		var err error
+		if param, ok := client.subscribes[k]; ok {
+			delete(client.subscribes, k)
+			err = client.namingClient.Unsubscribe(param)
+		} else {
+			err = client.configClient.CancelListenConfig(vo.ConfigParam{
+				DataId: k,
+				Group:  client.group,
+			})
+		}
+		if err != nil {
+			log.Warning(fmt.Sprintf("取消监听失败: %s, 错误: %v", k, err))
+		} else {
+			log.Info(fmt.Sprintf("取消监听: %s", k))
+		}
 	}
 }
+
+// changed 记录 k 发生了变化，并唤醒所有等待中的 WatchPrefix
+func (client *Client) changed(k string) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.index++
+	client.versions[k] = client.index
+	close(client.changes)
+	client.changes = make(chan struct{})
+}
@@ -0,0 +1,48 @@
+package nacos
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nacos-group/nacos-sdk-go/v2/clients/config_client"
+	"github.com/nacos-group/nacos-sdk-go/v2/vo"
+)
+
+// fakeConfigClient 只实现 GetConfig，与 SDK 一样对不存在的配置返回空内容
+type fakeConfigClient struct {
+	config_client.IConfigClient
+	configs map[string]string
+	err     error
+}
+
+func (c *fakeConfigClient) GetConfig(param vo.ConfigParam) (string, error) {
+	if c.err != nil {
+		return "", c.err
+	}
+	return c.configs[param.DataId], nil
+}
+
+func TestGetValuesReportsMissingKeys(t *testing.T) {
+	client := &Client{configClient: &fakeConfigClient{configs: map[string]string{"app.a": "1"}}}
+	vars, err := client.GetValues([]string{"/app/a", "/app/b"})
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("error = %v, want ErrKeyNotFound", err)
+	}
+	if !strings.Contains(err.Error(), "/app/b") || strings.Contains(err.Error(), "/app/a") {
+		t.Errorf("error = %v, want only the missing key", err)
+	}
+	if len(vars) != 1 || vars["/app/a"] != "1" {
+		t.Errorf("vars = %v, want the existing key", vars)
+	}
+
+	if _, err := client.GetValues([]string{"/app/a"}); err != nil {
+		t.Errorf("error = %v, want nil when every key exists", err)
+	}
+
+	// 其他错误原样返回，不当作键不存在
+	client.configClient = &fakeConfigClient{err: errors.New("timeout")}
+	if _, err := client.GetValues([]string{"/app/a"}); err == nil || errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("error = %v, want the request error", err)
+	}
+}
@@ -2,12 +2,14 @@
 package main
 
 import (
+	"context" // 用于停止处理器
 	"flag" // 用于解析命令行参数
 	"fmt" // 用于格式化输出
 	"os"   // 提供操作系统相关功能，如文件操作、退出程序等
 	"os/signal" // 用于接收操作系统信号
+	"reflect" // 用于比较后端配置是否变化
 	"runtime" // 提供运行时信息，如Go版本
-	"syscall" // 提供对底层系统调用的封装
+	"time" // 用于退出超时
 
 	// 导入自定义包
 	"github.com/Risingtao/nacos-confd/backends" // 后端存储客户端
@@ -19,6 +21,8 @@ import (
 func main() {
 	// 解析命令行参数
 	flag.Parse()
+	// 保存只包含命令行参数的配置，SIGHUP 时在此基础上重新读取配置文件
+	flagConfig := config
 	// 如果配置中要求打印版本信息，则打印并退出
 	if config.PrintVersion {
 		fmt.Printf("confd %s (Author: %s, Git SHA: %s, Go Version: %s)\n", Build_time, Author, GitSHA, runtime.Version())
@@ -30,6 +34,21 @@ func main() {
 		log.Fatal("初始化配置时出错: %v", err)
 	}
 
+	// confd rollback <dest>: 将目标文件恢复为最近一次备份后退出
+	if flag.Arg(0) == "rollback" {
+		if flag.NArg() != 2 {
+			log.Fatal("用法: confd [参数] rollback <dest>")
+		}
+		if err := template.Rollback(config.TemplateConfig, flag.Arg(1)); err != nil {
+			log.Fatal("回滚 %s 时出错: %v", flag.Arg(1), err)
+		}
+		os.Exit(0)
+	}
+
+	if config.ExitCode && !(config.OneTime && config.Noop) {
+		log.Warning("-exit-code 只在同时使用 -onetime 和 -noop 时生效")
+	}
+
 	// 启动confd，记录日志信息
 	log.Info("Starting confd")
 
@@ -39,45 +58,150 @@ func main() {
 		log.Fatal("创建后端存储客户端时出错: %v", err)
 	}
 
-	// 处理模板配置，将后端存储客户端传递给模板配置
-	config.TemplateConfig.StoreClient = storeClient
+	// 处理模板配置，将后端存储客户端和版本信息传递给模板配置
+	setTemplateConfig(storeClient)
 	// 如果配置中要求只处理一次，则处理模板配置并退出程序
 	if config.OneTime {
 		if err := template.Process(config.TemplateConfig); err != nil {
 			log.Fatal("处理模板配置时出错: %v", err)
 		}
+		// -exit-code 供 CI 判断是否存在配置漂移
+		if config.ExitCode && config.Noop && template.NoopChanges() > 0 {
+			log.Info("有 %d 个目标配置需要变更", template.NoopChanges())
+			os.Exit(2)
+		}
 		os.Exit(0)
 	}
 
-	// 创建控制通道，用于停止处理器和接收完成信号
-	stopChan := make(chan bool)
-	doneChan := make(chan bool)
 	errChan := make(chan error, 10) // 用于接收处理器错误
-
-	// 根据配置选择并创建处理器
-	var processor template.Processor
-	switch {
-	case config.Watch: // 如果配置中要求监听文件变化
-		processor = template.WatchProcessor(config.TemplateConfig, stopChan, doneChan, errChan)
-	default: // 否则使用定时处理
-		processor = template.IntervalProcessor(config.TemplateConfig, stopChan, doneChan, errChan, config.Interval)
-	}
-
-	// 启动处理器，使用goroutine异步执行
-	go processor.Process()
+	running := startProcessor(errChan)
 
 	// 设置信号处理循环，接收操作系统信号
 	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM) // 监听SIGINT和SIGTERM信号
+	signal.Notify(signalChan, notifySignals...)
 	for {
 		select {
 		case err := <-errChan: // 如果接收到处理器错误，则记录错误
 			log.Error("处理器出错: %v", err)
-		case s := <-signalChan: // 如果接收到操作系统信号，则准备退出程序
-			log.Info(fmt.Sprintf("捕获到信号 %v,准备退出...", s))
-			close(doneChan) // 关闭完成通道，通知处理器退出
-		case <-doneChan: // 如果接收到完成信号，则退出程序
-			os.Exit(0)
+		case s := <-signalChan:
+			switch s {
+			case reloadSignal: // 重新读取配置文件并重启处理器
+				log.Info("捕获到信号 %v,重新加载配置...", s)
+				storeClient, running = reloadConfig(flagConfig, storeClient, running, errChan)
+			case resyncSignal: // 立即处理所有模板资源
+				log.Info("捕获到信号 %v,立即重新处理所有模板资源", s)
+				running.processor.Resync()
+			default: // 其他信号则停止处理器后退出程序
+				log.Info(fmt.Sprintf("捕获到信号 %v,准备退出...", s))
+				os.Exit(running.shutdown(config.shutdownTimeout))
+			}
+		case <-running.done: // 如果处理器自行结束，则退出程序
+			os.Exit(running.exitCode())
+		}
+	}
+}
+
+// runningProcessor 是正在运行的处理器
+type runningProcessor struct {
+	processor template.Processor
+	cancel    context.CancelFunc
+	done      chan struct{} // Process 返回后关闭
+	err       error         // Process 的返回值，done 关闭后才能读取
+}
+
+// stop 通知处理器停止，并等待正在进行的处理结束。超过 timeout 仍未结束时返回 false
+func (r *runningProcessor) stop(timeout time.Duration) bool {
+	r.cancel()
+	select {
+	case <-r.done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// shutdown 停止处理器并返回程序的退出码。超过 timeout 仍未结束时删除残留的暂存文件，以状态码 1 强制退出
+func (r *runningProcessor) shutdown(timeout time.Duration) int {
+	if !r.stop(timeout) {
+		n := template.RemoveStageFiles()
+		log.Error("处理器未能在 %s 内结束，强制退出 (删除了 %d 个暂存文件)", timeout, n)
+		return 1
+	}
+	return r.exitCode()
+}
+
+// exitCode 返回处理器结束后程序的退出码：正常结束为 0，出错为 1
+func (r *runningProcessor) exitCode() int {
+	if r.err != nil {
+		log.Error("处理器异常结束: %v", r.err)
+		return 1
+	}
+	return 0
+}
+
+// setTemplateConfig 将后端存储客户端和版本信息传递给模板配置
+func setTemplateConfig(storeClient backends.StoreClient) {
+	config.TemplateConfig.StoreClient = storeClient
+	config.TemplateConfig.Version = Build_time
+	config.TemplateConfig.GitSHA = GitSHA
+}
+
+// startProcessor 根据当前配置创建处理器并异步运行
+func startProcessor(errChan chan error) *runningProcessor {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &runningProcessor{cancel: cancel, done: make(chan struct{})}
+	switch config.Mode {
+	case "watch": // 监听后端变化
+		r.processor = template.WatchProcessor(config.TemplateConfig, errChan)
+	case "hybrid": // 监听后端变化，同时定时处理
+		r.processor = template.HybridProcessor(config.TemplateConfig, errChan, config.Interval)
+	default: // 否则使用定时处理
+		r.processor = template.IntervalProcessor(config.TemplateConfig, errChan, config.Interval)
+	}
+	go func() {
+		defer close(r.done)
+		r.err = r.processor.Process(ctx)
+	}()
+	return r
+}
+
+// reloadConfig 在命令行参数的基础上重新读取配置文件。后端配置变化时重建后端存储客户端，
+// 然后停止当前的处理器并按新的配置启动。新的配置有误时继续使用原来的配置和处理器
+func reloadConfig(flagConfig Config, storeClient backends.StoreClient, running *runningProcessor, errChan chan error) (backends.StoreClient, *runningProcessor) {
+	previous := config
+	config = flagConfig
+	if err := initConfig(); err != nil {
+		log.Error("重新加载配置时出错，继续使用原来的配置: %v", err)
+		config = previous
+		return storeClient, running
+	}
+
+	newClient := storeClient
+	if !reflect.DeepEqual(config.BackendsConfig, previous.BackendsConfig) {
+		log.Info("后端配置已变化，重新创建后端存储客户端")
+		var err error
+		if newClient, err = backends.New(config.BackendsConfig); err != nil {
+			log.Error("创建后端存储客户端时出错，继续使用原来的配置: %v", err)
+			config = previous
+			return storeClient, running
+		}
+	}
+
+	if !running.stop(previous.shutdownTimeout) {
+		// 重新加载配置不能结束进程，等正在进行的处理结束后再按新的配置启动
+		log.Error("处理器未能在 %s 内结束，等待正在进行的处理完成后再应用新的配置", previous.shutdownTimeout)
+		<-running.done
+	}
+	if running.err != nil {
+		// 处理器已经因为错误结束，按新的配置重新启动
+		log.Error("处理器异常结束: %v", running.err)
+	}
+	if newClient != storeClient {
+		if c, ok := storeClient.(backends.Closer); ok {
+			c.Close()
 		}
 	}
+	setTemplateConfig(newClient)
+	log.Info("配置已重新加载，重新启动处理器")
+	return newClient, startProcessor(errChan)
 }
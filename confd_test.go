@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Risingtao/nacos-confd/resource/template"
+)
+
+// memStore 是只读的内存 StoreClient
+type memStore map[string]string
+
+func (s memStore) GetValues(keys []string) (map[string]string, error) {
+	values := make(map[string]string)
+	for _, k := range keys {
+		if v, ok := s[k]; ok {
+			values[k] = v
+		}
+	}
+	return values, nil
+}
+
+func (s memStore) WatchPrefix(ctx context.Context, prefix string, keys []string, waitIndex uint64) (uint64, error) {
+	<-ctx.Done()
+	return waitIndex, ctx.Err()
+}
+
+// setupConfDir 创建一个包含单个资源的 confdir，check_cmd 为 checkCmd，返回目标文件所在的目录
+func setupConfDir(t *testing.T, checkCmd string) string {
+	dir, err := ioutil.TempDir("", "confd-main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	out := filepath.Join(dir, "out")
+	for _, d := range []string{"conf.d", "templates", "out"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]string{
+		"templates/t.tmpl": `{{getv "/a"}}`,
+		"conf.d/t.toml":    "[template]\nsrc = \"t.tmpl\"\ndest = \"" + filepath.Join(out, "t.conf") + "\"\ncheck_cmd = \"" + checkCmd + "\"\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	previous := config
+	t.Cleanup(func() { config = previous })
+	config.Mode = "interval"
+	config.Interval = 3600
+	config.TemplateConfig = template.Config{
+		ConfDir:     dir,
+		ConfigDir:   filepath.Join(dir, "conf.d"),
+		TemplateDir: filepath.Join(dir, "templates"),
+		StoreClient: &memStore{"/a": "1"},
+		Prefix:      "/",
+	}
+	return out
+}
+
+// waitForStageFile 等待目标目录中出现暂存文件，返回其路径
+func waitForStageFile(t *testing.T, out string) string {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if m, _ := filepath.Glob(filepath.Join(out, ".t.conf*")); len(m) > 0 {
+			return m[0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("没有生成暂存文件")
+	return ""
+}
+
+func TestStopWaitsForInFlightSync(t *testing.T) {
+	out := setupConfDir(t, "sleep 0.3")
+	running := startProcessor(make(chan error, 10))
+	waitForStageFile(t, out)
+
+	if !running.stop(5 * time.Second) {
+		t.Fatal("stop timed out")
+	}
+	if code := running.exitCode(); code != 0 {
+		t.Errorf("exitCode() = %d, want 0", code)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(out, "t.conf"))
+	if err != nil || string(data) != "1" {
+		t.Errorf("t.conf = %q, %v; want the in-flight sync to finish", data, err)
+	}
+}
+
+func TestShutdownTimeoutRemovesStageFiles(t *testing.T) {
+	out := setupConfDir(t, "sleep 2")
+	running := startProcessor(make(chan error, 10))
+	staged := waitForStageFile(t, out)
+
+	start := time.Now()
+	if code := running.shutdown(100 * time.Millisecond); code != 1 {
+		t.Fatalf("shutdown() = %d, want 1 after the timeout", code)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("shutdown took %s, want about the 100ms timeout", d)
+	}
+	if _, err := os.Stat(staged); !os.IsNotExist(err) {
+		t.Errorf("stage file %s was not removed: %v", staged, err)
+	}
+	// 等处理器结束，避免影响其他测试
+	<-running.done
+}
+
+func TestReloadConfigWaitsPastShutdownTimeout(t *testing.T) {
+	out := setupConfDir(t, "sleep 0.5")
+	config.ConfigFile = filepath.Join(t.TempDir(), "confd.toml")
+	config.ShutdownTimeout = "50ms"
+	config.shutdownTimeout = 50 * time.Millisecond
+	flagConfig := config
+	errChan := make(chan error, 10)
+	running := startProcessor(errChan)
+	staged := waitForStageFile(t, out)
+
+	// 超过 shutdown_timeout 时重新加载不能退出进程，也不能删除正在使用的暂存文件
+	_, next := reloadConfig(flagConfig, config.StoreClient, running, errChan)
+	defer next.stop(5 * time.Second)
+	select {
+	case <-running.done:
+	default:
+		t.Fatal("reloadConfig returned before the previous processor finished")
+	}
+	if next == running {
+		t.Fatal("reloadConfig did not start a new processor")
+	}
+	data, err := ioutil.ReadFile(filepath.Join(out, "t.conf"))
+	if err != nil || string(data) != "1" {
+		t.Errorf("t.conf = %q, %v; want the in-flight sync to finish", data, err)
+	}
+	if _, err := os.Stat(staged); !os.IsNotExist(err) {
+		t.Errorf("stage file %s left behind: %v", staged, err)
+	}
+}
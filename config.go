@@ -1,9 +1,11 @@
 // 导入必要的包
 import (
 	"flag" // 用于解析命令行参数
+	"fmt" // 用于格式化错误信息
 	"io/ioutil" // 用于读取文件内容
 	"os" // 操作系统相关功能，如文件操作
 	"path/filepath" // 用于处理文件路径
+	"time" // 用于解析退出超时
 
 	// 导入项目内部的包
 	"github.com/Risingtao/nacos-confd/backends" // 后端配置相关
@@ -26,9 +28,14 @@ type Config struct {
 	SRVRecord     string `toml:"srv_record"` // 服务记录
 	LogLevel      string `toml:"log-level"` // 日志级别
 	Watch         bool   `toml:"watch"` // 是否启用监听
+	Mode          string `toml:"mode"` // 处理方式: interval、watch 或 hybrid，为空时由 watch 决定
 	PrintVersion  bool   // 是否打印版本信息
 	ConfigFile    string // 配置文件路径
 	OneTime       bool   // 是否只运行一次
+	ExitCode      bool   // -onetime -noop 发现待变更时以状态码 2 退出
+	ShutdownTimeout string `toml:"shutdown_timeout"` // 退出或重新加载时等待处理结束的最长时间
+
+	shutdownTimeout time.Duration // 解析后的 ShutdownTimeout
 }
 
 // 全局变量config用于存储配置信息
@@ -39,11 +46,14 @@ func init() {
 	// 使用flag包定义命令行参数
 	flag.StringVar(&config.AuthToken, "auth-token", "", "Auth bearer token to use")
 	flag.StringVar(&config.Backend, "backend", "etcd", "backend to use")
+	flag.StringVar(&config.BackupDir, "backup-dir", "", "directory for dest backups (default <confdir>/backups)")
+	flag.StringVar(&config.StateDir, "state-dir", "", "directory for runtime state such as generated foreach dests (default <confdir>/state)")
 	flag.StringVar(&config.ClientCaKeys, "client-ca-keys", "", "client ca keys")
 	flag.StringVar(&config.ClientCert, "client-cert", "", "the client cert")
 	flag.StringVar(&config.ClientKey, "client-key", "", "the client key")
 	flag.StringVar(&config.ConfDir, "confdir", "/etc/confd", "confd conf directory")
 	flag.StringVar(&config.ConfigFile, "config-file", "/etc/confd/confd.toml", "the confd config file")
+	flag.BoolVar(&config.ExitCode, "exit-code", false, "with -onetime -noop, exit with status 2 if any dest would change")
 	flag.IntVar(&config.Interval, "interval", 600, "backend polling interval")
 	flag.BoolVar(&config.KeepStageFile, "keep-stage-file", false, "keep staged files")
 	flag.StringVar(&config.LogLevel, "log-level", "", "level which confd should log messages")
@@ -52,9 +62,13 @@ func init() {
 	flag.BoolVar(&config.OneTime, "onetime", false, "run once and exit")
 	flag.StringVar(&config.Prefix, "prefix", "", "key path prefix")
 	flag.BoolVar(&config.PrintVersion, "version", false, "print version and exit")
+	flag.StringVar(&config.ShutdownTimeout, "shutdown-timeout", "30s", "maximum time to wait for in-flight syncs on shutdown or reload")
 	flag.StringVar(&config.Scheme, "scheme", "http", "the backend URI scheme for nodes retrieved from DNS SRV records (http or https)")
 	flag.StringVar(&config.SecretKeyring, "secret-keyring", "", "path to armored PGP secret keyring (for use with crypt functions)")
 	flag.BoolVar(&config.SyncOnly, "sync-only", false, "sync without check_cmd and reload_cmd")
+	flag.StringVar(&config.ReloadDebounce, "reload-debounce", "", "default window for coalescing identical reload_cmd runs, e.g. 2s")
+	flag.StringVar(&config.ReloadMinInterval, "reload-min-interval", "", "default minimum interval between runs of the same reload_cmd, e.g. 10s")
+	flag.BoolVar(&config.Strict, "strict", false, "fail rendering on missing keys, failed lookups and <no value> output")
 	flag.StringVar(&config.AuthType, "auth-type", "", "Vault auth backend type to use (only used with -backend=vault)")
 	flag.StringVar(&config.Endpoint, "endpoint", "", "the endpoint in nacos (only used with nacos backends)")
 	flag.StringVar(&config.Group, "group", "DEFAULT_GROUP", "the group in nacos (only used with nacos backends)")
@@ -64,6 +78,22 @@ func init() {
 	flag.BoolVar(&config.OpenKMS, "openKMS", false, "the switch if open kms in nacos (only used with nacos backends)")
 	flag.StringVar(&config.RegionId, "regionId", "", "the kms regionId in nacos (only used with nacos backends)")
 	flag.BoolVar(&config.Watch, "watch", false, "enable watch support")
+	flag.StringVar(&config.Mode, "mode", "", "processing mode: interval, watch or hybrid (watch plus a full pass every interval); overrides -watch")
+	flag.IntVar(&config.Workers, "workers", 1, "number of resources processed concurrently in interval and onetime mode")
+}
+
+// reapplyFlags 重新解析命令行，让显式设置的参数覆盖配置文件中的值。
+// -node 每次出现都会追加节点，因此先清空配置文件中的节点
+func reapplyFlags() error {
+	if !flag.Parsed() {
+		return nil
+	}
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "node" {
+			config.BackendNodes = nil
+		}
+	})
+	return flag.CommandLine.Parse(os.Args[1:])
 }
 
 // initConfig函数用于初始化配置信息
@@ -85,21 +115,47 @@ func initConfig() error {
 		if err != nil {
 			return err
 		}
+		// 命令行中显式设置的参数优先于配置文件
+		if err := reapplyFlags(); err != nil {
+			return err
+		}
 	}
 
 	// 如果指定了密钥环路径，则读取密钥环内容
 	if config.SecretKeyring != "" {
 		kr, err := os.Open(config.SecretKeyring)
 		if err != nil {
-			log.Fatal(err.Error())
+			return err
 		}
 		defer kr.Close()
 		config.PGPPrivateKey, err = ioutil.ReadAll(kr)
 		if err != nil {
-			log.Fatal(err.Error())
+			return err
 		}
 	}
 
+	// 解析退出超时
+	config.shutdownTimeout, err = time.ParseDuration(config.ShutdownTimeout)
+	if err != nil || config.shutdownTimeout <= 0 {
+		return fmt.Errorf("无效的 shutdown_timeout %q", config.ShutdownTimeout)
+	}
+
+	// 未指定处理方式时按 watch 选择，兼容原来的配置
+	switch config.Mode {
+	case "":
+		if config.Watch {
+			config.Mode = "watch"
+		} else {
+			config.Mode = "interval"
+		}
+	case "interval", "watch", "hybrid":
+	default:
+		return fmt.Errorf("无效的 mode %q，可选 interval、watch、hybrid", config.Mode)
+	}
+	if config.Mode == "hybrid" && config.Interval <= 0 {
+		return fmt.Errorf("mode = \"hybrid\" 需要大于 0 的 interval")
+	}
+
 	// 如果指定了日志级别，则设置日志级别
 	if config.LogLevel != "" {
 		log.SetLevel(config.LogLevel)
@@ -3,7 +3,11 @@ module github.com/Risingtao/nacos-confd
 go 1.15
 
 require (
+	github.com/coreos/go-systemd/v22 v22.5.0
+	github.com/fsnotify/fsnotify v1.7.0
+	github.com/godbus/dbus/v5 v5.0.4
 	github.com/nacos-group/nacos-sdk-go/v2 v2.2.7
 	github.com/sirupsen/logrus v1.9.3
 	golang.org/x/crypto v0.23.0
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,286 @@
+package template
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/Risingtao/nacos-confd/depends/toml"
+	"github.com/Risingtao/nacos-confd/log"
+	"github.com/Risingtao/nacos-confd/util"
+)
+
+// backupTimeFormat 是备份文件的命名格式，按文件名排序即按时间排序
+const backupTimeFormat = "20060102T150405.000000000"
+
+// RollbackError 表示目标文件更新后重新加载或健康检查失败，已恢复为更新前的版本
+type RollbackError struct {
+	Dest  string
+	Cause error // 触发回滚的错误
+	Err   error // 回滚过程中的错误，为 nil 表示回滚成功
+}
+
+func (e *RollbackError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s 更新后失败 (%v)，回滚也失败: %v", e.Dest, e.Cause, e.Err)
+	}
+	return fmt.Sprintf("%s 更新后失败，已回滚到上一版本: %v", e.Dest, e.Cause)
+}
+
+func (e *RollbackError) Unwrap() error {
+	return e.Cause
+}
+
+// defaultBackupDir 返回未设置 backup_dir 时使用的备份目录
+func defaultBackupDir(config Config) string {
+	if config.BackupDir != "" {
+		return config.BackupDir
+	}
+	return filepath.Join(config.ConfDir, "backups")
+}
+
+// backupPath 返回 dest 的各版本备份所在的目录，目录结构与 dest 的路径相同
+func backupPath(backupDir, dest string) string {
+	return filepath.Join(backupDir, dest)
+}
+
+// listBackups 按时间从旧到新返回 dir 中的备份文件
+func listBackups(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var backups []string
+	for _, e := range entries {
+		if !e.Mode().IsRegular() {
+			continue
+		}
+		if _, err := time.Parse(backupTimeFormat, e.Name()); err != nil {
+			continue
+		}
+		backups = append(backups, filepath.Join(dir, e.Name()))
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
+
+// backupDest 在替换目标文件之前保存其当前版本，只保留最近 Backup 个版本。
+// 返回备份文件的路径，目标文件不存在时返回空字符串
+func (t *TemplateResource) backupDest() (string, error) {
+	if !util.IsFileExist(t.Dest) {
+		return "", nil
+	}
+	dir := backupPath(t.BackupDir, t.Dest)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("创建备份目录失败: %v", err)
+	}
+	backups, err := listBackups(dir)
+	if err != nil {
+		return "", fmt.Errorf("读取备份目录失败: %v", err)
+	}
+	// 最近的备份与当前文件相同时（例如上次回滚后）不再重复备份
+	if n := len(backups); n > 0 && sameFile(backups[n-1], t.Dest) {
+		return backups[n-1], nil
+	}
+
+	name := filepath.Join(dir, time.Now().Format(backupTimeFormat))
+	if err := copyFile(t.Dest, name); err != nil {
+		return "", fmt.Errorf("备份 %s 失败: %v", t.Dest, err)
+	}
+	log.Debug("已备份 %s 到 %s", t.Dest, name)
+
+	backups = append(backups, name)
+	for len(backups) > t.Backup {
+		if err := os.Remove(backups[0]); err != nil {
+			log.Warning("删除旧备份 %s 失败: %v", backups[0], err)
+		}
+		backups = backups[1:]
+	}
+	return name, nil
+}
+
+// rollback 在 cause 导致更新失败后将目标文件恢复为 backup（为空表示更新前不存在，
+// 直接删除），再次执行 reload_cmd，并作为回滚事件发送通知
+func (t *TemplateResource) rollback(backup string, cause error) error {
+	return t.rollbackDest(t.Dest, backup, cause, t.reload)
+}
+
+// rollbackDest 与 rollback 相同，但操作给定的 dest，并用 rerun 再次执行 reload
+func (t *TemplateResource) rollbackDest(dest, backup string, cause error, rerun func() error) error {
+	log.Error("%s 更新后失败，开始回滚: %v", dest, cause)
+	rerr := &RollbackError{Dest: dest, Cause: cause}
+	if backup == "" {
+		rerr.Err = os.Remove(dest)
+	} else {
+		rerr.Err = copyFile(backup, dest)
+	}
+	if rerr.Err == nil && t.ReloadCmd != "" {
+		rerr.Err = rerun()
+	}
+
+	if rerr.Err != nil {
+		log.Error("%v", rerr)
+	} else {
+		log.Warning("目标配置 %s 已回滚到上一版本", dest)
+	}
+	if err := t.notify(dest, "rollback", "配置回滚通知"); err != nil {
+		log.Warning("发送回滚通知失败: %v", err)
+	}
+	return rerr
+}
+
+// healthCheck 在重新加载之后执行 health_check_cmd
+func (t *TemplateResource) healthCheck() error {
+	log.Debug("执行健康检查: %s", t.HealthCheckCmd)
+	return t.runRecorded(t.healthCheckCommand())
+}
+
+// Rollback 将 dest 恢复为最近一次备份，并删除该备份，因此重复执行会逐个回退到更早的版本。
+// 若 conf.d 中有资源生成该文件，会使用其 backup_dir，并在恢复后执行 reload_cmd 和 health_check_cmd。
+func Rollback(config Config, dest string) error {
+	if !filepath.IsAbs(dest) {
+		return fmt.Errorf("dest 必须是绝对路径: %s", dest)
+	}
+	t, err := findResourceForDest(config, filepath.Clean(dest))
+	if err != nil {
+		return err
+	}
+
+	backups, err := listBackups(backupPath(t.BackupDir, t.Dest))
+	if err != nil {
+		return fmt.Errorf("读取备份目录失败: %v", err)
+	}
+	if len(backups) == 0 {
+		return fmt.Errorf("%s 在 %s 中没有备份", t.Dest, t.BackupDir)
+	}
+	latest := backups[len(backups)-1]
+	// 当前文件可能就是最近的备份（例如自动回滚之后），此时恢复更早的一个版本
+	if sameFile(latest, t.Dest) {
+		if len(backups) == 1 {
+			return fmt.Errorf("%s 与唯一的备份相同，没有更早的版本", t.Dest)
+		}
+		os.Remove(latest)
+		latest = backups[len(backups)-2]
+	}
+
+	if config.Noop {
+		log.Warning("Noop 模式已启用。%s 不会被恢复为 %s", t.Dest, latest)
+		return nil
+	}
+	if err := copyFile(latest, t.Dest); err != nil {
+		return fmt.Errorf("恢复 %s 失败: %v", t.Dest, err)
+	}
+	os.Remove(latest)
+	log.Info("目标配置 %s 已恢复为备份 %s", t.Dest, filepath.Base(latest))
+
+	if config.SyncOnly {
+		return nil
+	}
+	if t.ReloadCmd != "" {
+		if err := t.reload(); err != nil {
+			return fmt.Errorf("重新加载配置失败: %v", err)
+		}
+	}
+	if t.HealthCheckCmd != "" {
+		if err := t.healthCheck(); err != nil {
+			return fmt.Errorf("健康检查失败: %v", err)
+		}
+	}
+	return nil
+}
+
+// findResourceForDest 在 conf.d 中查找生成 dest 的资源，优先匹配固定的 dest，
+// 其次是模板化 dest 且备份目录中有该文件备份的资源；都没有时使用默认备份目录
+func findResourceForDest(config Config, dest string) (*TemplateResource, error) {
+	var paths []string
+	if util.IsFileExist(config.ConfigDir) {
+		var err error
+		if paths, err = util.RecursiveFilesLookup(config.ConfigDir, "*toml"); err != nil {
+			return nil, fmt.Errorf("查找文件出错: %w", err)
+		}
+	}
+
+	var candidate *TemplateResource
+	for _, p := range paths {
+		tc := &TemplateResourceConfig{&TemplateResource{}}
+		if _, err := toml.DecodeFile(p, tc); err != nil {
+			log.Warning("无法处理模板资源 %s - %v", p, err)
+			continue
+		}
+		tr := tc.TemplateResource
+		if tr.BackupDir == "" {
+			tr.BackupDir = defaultBackupDir(config)
+		}
+		tr.resourcePath = p
+		if err := tr.setupCommands(); err != nil {
+			log.Warning("无法处理模板资源 %s - %v", p, err)
+			continue
+		}
+		if filepath.Clean(tr.Dest) == dest {
+			return tr, nil
+		}
+		if candidate == nil && tr.Dest != "" && util.IsFileExist(backupPath(tr.BackupDir, dest)) {
+			tr.Dest = dest
+			candidate = tr
+		}
+	}
+	if candidate != nil {
+		return candidate, nil
+	}
+	return &TemplateResource{Dest: dest, BackupDir: defaultBackupDir(config)}, nil
+}
+
+// sameFile 报告两个文件的内容、权限和所有者是否相同
+func sameFile(a, b string) bool {
+	fa, err := util.FileStat(a)
+	if err != nil {
+		return false
+	}
+	fb, err := util.FileStat(b)
+	if err != nil {
+		return false
+	}
+	return fa == fb
+}
+
+// copyFile 将 src 连同权限和所有者复制到 dst
+func copyFile(src, dst string) error {
+	fi, err := util.FileStat(src)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(dst, data, fi)
+}
+
+// writeFileAtomic 以 fi 中的权限和所有者写入 dst，先写入同目录的临时文件再重命名
+func writeFileAtomic(dst string, data []byte, fi util.FileInfo) error {
+	temp, err := ioutil.TempFile(filepath.Dir(dst), "."+filepath.Base(dst))
+	if err != nil {
+		return err
+	}
+	_, err = temp.Write(data)
+	if cerr := temp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	os.Chmod(temp.Name(), fi.Mode)
+	os.Chown(temp.Name(), int(fi.Uid), int(fi.Gid))
+	if err := os.Rename(temp.Name(), dst); err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package template
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFailedUpdateRestoresDest(t *testing.T) {
+	// 没有结尾换行、带 CRLF 和 NUL 的内容，确认恢复的是原始字节
+	original := "old\r\nline\x00 without newline"
+	tests := []struct {
+		name     string
+		extra    string
+		existing bool
+		rollback bool
+		wantErr  string
+	}{
+		{name: "check_cmd", extra: "check_cmd = \"! grep -q bad {{.src}}\"\n", existing: true, wantErr: "配置检查失败"},
+		{name: "reload_cmd", extra: "reload_cmd = \"! grep -q bad {{out}}/t.conf\"\n", existing: true, rollback: true, wantErr: "重新加载配置失败"},
+		{name: "health_check_cmd", extra: "reload_cmd = \"true\"\nhealth_check_cmd = \"! grep -q bad {{out}}/t.conf\"\n", existing: true, rollback: true, wantErr: "健康检查失败"},
+		{name: "new file", extra: "reload_cmd = \"! grep -q bad {{out}}/t.conf\"\n", rollback: true, wantErr: "重新加载配置失败"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEnv(t, map[string]string{"/a": "bad"})
+			e.writeTemplate("t.tmpl", `{{getv "/a"}}`)
+			e.writeResource("t.toml", "[template]\nsrc = \"t.tmpl\"\ndest = \"{{out}}/t.conf\"\nkeys = [\"/a\"]\nbackup = 3\n"+tt.extra)
+			dest := filepath.Join(e.out, "t.conf")
+			if tt.existing {
+				if err := ioutil.WriteFile(dest, []byte(original), 0640); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Chmod(dest, 0640); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := e.resource("t.toml").process()
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("process = %v, want error containing %q", err, tt.wantErr)
+			}
+			var rerr *RollbackError
+			if got := errors.As(err, &rerr); got != tt.rollback {
+				t.Fatalf("error = %v, rollback = %v, want %v", err, got, tt.rollback)
+			}
+			if rerr != nil && rerr.Err != nil {
+				t.Fatalf("rollback failed: %v", rerr.Err)
+			}
+
+			if !tt.existing {
+				if _, err := os.Stat(dest); !os.IsNotExist(err) {
+					t.Fatalf("stat t.conf = %v, want the new file removed", err)
+				}
+				return
+			}
+			data, err := ioutil.ReadFile(dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != original {
+				t.Errorf("t.conf = %q, want %q restored", data, original)
+			}
+			fi, err := os.Stat(dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi.Mode().Perm() != 0640 {
+				t.Errorf("t.conf mode = %v, want 0640 restored", fi.Mode().Perm())
+			}
+		})
+	}
+}
+
+func TestBackupRetention(t *testing.T) {
+	e := newTestEnv(t, map[string]string{"/a": "1"})
+	e.writeTemplate("t.tmpl", `a={{getv "/a"}}`)
+	e.writeResource("t.toml", "[template]\nsrc = \"t.tmpl\"\ndest = \"{{out}}/t.conf\"\nkeys = [\"/a\"]\nbackup = 2\n")
+	tr := e.resource("t.toml")
+	for _, v := range []string{"1", "2", "3", "4", "5"} {
+		e.store.set("/a", v)
+		if err := tr.process(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 只保留替换前最近的 2 个版本
+	backups, err := listBackups(backupPath(tr.BackupDir, tr.Dest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, b := range backups {
+		data, err := ioutil.ReadFile(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(data))
+	}
+	if want := []string{"a=3", "a=4"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("backups = %q, want %q", got, want)
+	}
+	if got := e.readOut("t.conf"); got != "a=5" {
+		t.Errorf("t.conf = %q, want a=5", got)
+	}
+
+	// 手动回滚逐个回退到更早的版本
+	for _, want := range []string{"a=4", "a=3"} {
+		if err := Rollback(e.config, tr.Dest); err != nil {
+			t.Fatal(err)
+		}
+		if got := e.readOut("t.conf"); got != want {
+			t.Errorf("t.conf after Rollback = %q, want %q", got, want)
+		}
+	}
+	if err := Rollback(e.config, tr.Dest); err == nil {
+		t.Error("Rollback with no backups left succeeded")
+	}
+}
@@ -0,0 +1,99 @@
+package template
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// fileStamp 记录文件的修改时间和大小，用于判断缓存是否失效
+type fileStamp struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+// stampFiles 返回各文件当前的 fileStamp
+func stampFiles(paths ...string) ([]fileStamp, error) {
+	stamps := make([]fileStamp, 0, len(paths))
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		stamps = append(stamps, fileStamp{path: p, modTime: fi.ModTime(), size: fi.Size()})
+	}
+	return stamps, nil
+}
+
+// sameStamps 报告两组 fileStamp 是否对应同样的文件且均未变化
+func sameStamps(a, b []fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].path != b[i].path || !a[i].modTime.Equal(b[i].modTime) || a[i].size != b[i].size {
+			return false
+		}
+	}
+	return true
+}
+
+// resourceCache 按路径缓存已加载的模板资源和资源组。conf.d 中的文件未变化时直接复用
+// 上一次的 TemplateResource，这样资源的状态（已解析的模板、已生成的目标文件等）
+// 也能在定时处理的各个周期之间保留。
+type resourceCache struct {
+	mu      sync.Mutex
+	entries map[string]*cachedResource
+}
+
+type cachedResource struct {
+	stamp    []fileStamp
+	resource *TemplateResource
+	group    *resourceGroup
+}
+
+func newResourceCache() *resourceCache {
+	return &resourceCache{entries: make(map[string]*cachedResource)}
+}
+
+// load 返回 path 对应的模板资源或资源组，文件的修改时间或大小变化后重新加载
+func (c *resourceCache) load(path string, config Config) (*TemplateResource, *resourceGroup, error) {
+	stamp, err := stampFiles(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[path]; ok && sameStamps(e.stamp, stamp) {
+		return e.resource, e.group, nil
+	}
+
+	t, g, err := loadConfFile(path, config)
+	if err != nil {
+		delete(c.entries, path)
+		return nil, nil, err
+	}
+	if e, ok := c.entries[path]; ok && t != nil && e.resource != nil {
+		// 保留已生成的目标文件列表，以便重新加载后仍能清理不再生成的文件
+		t.renderedDests = e.resource.renderedDests
+	}
+	c.entries[path] = &cachedResource{stamp: stamp, resource: t, group: g}
+	return t, g, nil
+}
+
+// prune 删除不在 paths 中的缓存项
+func (c *resourceCache) prune(paths []string) {
+	keep := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		keep[p] = true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for p := range c.entries {
+		if !keep[p] {
+			delete(c.entries, p)
+		}
+	}
+}
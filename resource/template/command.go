@@ -0,0 +1,373 @@
+package template
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/Risingtao/nacos-confd/log"
+)
+
+// maxCommandOutput 是记录到资源状态中的命令输出的最大字节数，超出部分被丢弃
+const maxCommandOutput = 64 * 1024
+
+// command 描述一次要执行的外部命令
+type command struct {
+	name    string   // 命令的用途，例如 check、reload，用于日志
+	shell   string   // 通过 shell 执行的命令
+	argv    []string // 不经过 shell 直接执行的命令，设置后忽略 shell
+	timeout time.Duration
+	env     []string // 追加到当前进程环境变量之后
+	runAs   *credential
+	fn      func() (string, error) // 在进程内执行的操作，设置后 shell 只用于描述
+}
+
+// CommandResult 是一次外部命令执行的结果
+type CommandResult struct {
+	Command   string
+	Output    string // 合并的标准输出和标准错误，最多保留 maxCommandOutput 字节
+	Truncated bool
+	ExitCode  int
+	TimedOut  bool
+	Started   time.Time
+	Duration  time.Duration
+}
+
+// String 返回用于日志和合并 reload 的命令文本
+func (c *command) String() string {
+	if len(c.argv) > 0 {
+		return formatArgv(c.argv)
+	}
+	return c.shell
+}
+
+// run 执行命令，超时后结束整个进程组
+func (c *command) run() (*CommandResult, error) {
+	log.Debug("运行脚本: " + c.String())
+	if c.fn != nil {
+		return c.runFunc()
+	}
+
+	ctx := context.Background()
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	var cmd *exec.Cmd
+	if len(c.argv) > 0 {
+		cmd = exec.Command(c.argv[0], c.argv[1:]...)
+	} else {
+		cmd = shellCommand(c.shell)
+	}
+	if len(c.env) > 0 {
+		cmd.Env = append(os.Environ(), c.env...)
+	}
+	output := &limitedBuffer{limit: maxCommandOutput}
+	cmd.Stdout = output
+	cmd.Stderr = output
+	if err := prepareCommand(cmd, c.runAs); err != nil {
+		return nil, err
+	}
+
+	res := &CommandResult{Command: c.String(), Started: time.Now()}
+	err := cmd.Start()
+	if err == nil {
+		done := make(chan error, 1)
+		go func() { done <- cmd.Wait() }()
+		select {
+		case err = <-done:
+		case <-ctx.Done():
+			log.Error("脚本执行超过 %s，结束进程组: %s", c.timeout, c.String())
+			if kerr := killCommand(cmd); kerr != nil {
+				log.Warning("结束进程组失败: %v", kerr)
+			}
+			<-done
+			res.TimedOut = true
+			err = fmt.Errorf("执行超时 (%s)", c.timeout)
+		}
+	}
+	res.Duration = time.Since(res.Started)
+	res.Output = output.String()
+	res.Truncated = output.truncated
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		res.ExitCode = exitErr.ExitCode()
+	} else if cmd.ProcessState != nil {
+		res.ExitCode = cmd.ProcessState.ExitCode()
+	}
+
+	if err != nil {
+		log.Error("脚本执行失败: %s, 错误: %v", res.Output, err)
+		return res, fmt.Errorf("脚本执行失败: %s, 错误: %v", res.Output, err)
+	}
+	log.Debug("脚本输出信息 >>>>\n%s", strings.TrimSpace(res.Output))
+	return res, nil
+}
+
+// runFunc 执行进程内的操作，结果的格式与外部命令相同。超过 timeout 时不再等待操作结束，
+// 操作本身（例如 D-Bus 调用）也按同样的时限结束
+func (c *command) runFunc() (*CommandResult, error) {
+	res := &CommandResult{Command: c.String(), Started: time.Now()}
+	type result struct {
+		output string
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		output, err := c.fn()
+		done <- result{output, err}
+	}()
+	var timeout <-chan time.Time
+	if c.timeout > 0 {
+		timer := time.NewTimer(c.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	var output string
+	var err error
+	select {
+	case r := <-done:
+		output, err = r.output, r.err
+	case <-timeout:
+		res.TimedOut = true
+		err = fmt.Errorf("执行超时 (%s)", c.timeout)
+	}
+	res.Duration = time.Since(res.Started)
+	res.Output = output
+	if err != nil {
+		res.ExitCode = 1
+		log.Error("%s 执行失败: %v", c.String(), err)
+		return res, err
+	}
+	log.Debug("%s 执行成功 >>>>\n%s", c.String(), strings.TrimSpace(output))
+	return res, nil
+}
+
+// runCommand 通过 shell 执行 cmd，不限制执行时间
+func runCommand(cmd string) error {
+	_, err := (&command{shell: cmd}).run()
+	return err
+}
+
+// limitedBuffer 只保存前 limit 个字节，其余的写入被丢弃但仍视为成功
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); room < len(p) {
+		b.truncated = true
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *limitedBuffer) String() string {
+	if b.truncated {
+		return b.buf.String() + "\n...(输出已截断)"
+	}
+	return b.buf.String()
+}
+
+// formatArgv 将 argv 格式化为便于阅读的命令行，含空白或引号的参数加上引号
+func formatArgv(argv []string) string {
+	parts := make([]string, len(argv))
+	for i, a := range argv {
+		if a == "" || strings.ContainsAny(a, " \t\n'\"\\$") {
+			parts[i] = fmt.Sprintf("%q", a)
+		} else {
+			parts[i] = a
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// renderCommand 用 data 渲染命令中的 {{.src}} 等变量
+func renderCommand(name, text string, data interface{}) (string, error) {
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buffer bytes.Buffer
+	if err := tmpl.Execute(&buffer, data); err != nil {
+		return "", err
+	}
+	return buffer.String(), nil
+}
+
+// ResourceStatus 记录资源最近一次执行各类命令的结果
+type ResourceStatus struct {
+	Check       *CommandResult
+	Reload      *CommandResult
+	HealthCheck *CommandResult
+}
+
+type resourceStatus struct {
+	mu sync.Mutex
+	ResourceStatus
+}
+
+// Status 返回资源最近一次执行 check_cmd、reload_cmd 和 health_check_cmd 的结果
+func (t *TemplateResource) Status() ResourceStatus {
+	if t.status == nil {
+		return ResourceStatus{}
+	}
+	t.status.mu.Lock()
+	defer t.status.mu.Unlock()
+	return t.status.ResourceStatus
+}
+
+// logFailedCommands 输出本轮处理中执行失败的命令的结果
+func (t *TemplateResource) logFailedCommands() {
+	st := t.Status()
+	for _, c := range []struct {
+		name string
+		res  *CommandResult
+	}{{"check", st.Check}, {"reload", st.Reload}, {"health_check", st.HealthCheck}} {
+		if c.res == nil || c.res.Started.Before(t.cycleStarted) || (c.res.ExitCode == 0 && !c.res.TimedOut) {
+			continue
+		}
+		log.Error("模板 %s 的 %s 命令失败: %s", t.Src, c.name, c.res)
+	}
+}
+
+// String 返回结果的摘要，用于日志
+func (r *CommandResult) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s (退出码 %d，耗时 %s", r.Command, r.ExitCode, r.Duration.Round(time.Millisecond))
+	if r.TimedOut {
+		b.WriteString("，已超时")
+	}
+	b.WriteString(")")
+	if out := strings.TrimSpace(r.Output); out != "" {
+		b.WriteString("，输出:\n" + out)
+		if r.Truncated {
+			b.WriteString("\n...（输出已截断）")
+		}
+	}
+	return b.String()
+}
+
+// recordResult 将命令的执行结果记录到资源状态
+func (t *TemplateResource) recordResult(name string, res *CommandResult) {
+	if t.status == nil || res == nil {
+		return
+	}
+	t.status.mu.Lock()
+	defer t.status.mu.Unlock()
+	switch name {
+	case "check":
+		t.status.Check = res
+	case "reload":
+		t.status.Reload = res
+	case "health_check":
+		t.status.HealthCheck = res
+	}
+}
+
+// runRecorded 执行命令并把结果记录到资源状态
+func (t *TemplateResource) runRecorded(c *command) error {
+	res, err := c.run()
+	t.recordResult(c.name, res)
+	return err
+}
+
+// setupCommands 校验并解析命令和钩子相关的选项：argv 形式、超时和 run_as
+func (t *TemplateResource) setupCommands() error {
+	if len(t.CheckArgv) > 0 {
+		if t.CheckCmd != "" {
+			return errors.New("check_cmd 与 check_argv 不能同时设置")
+		}
+		// CheckCmd 用于日志和判断是否需要检查，执行时使用 argv
+		t.CheckCmd = formatArgv(t.CheckArgv)
+	}
+	if len(t.ReloadArgv) > 0 {
+		if t.ReloadCmd != "" {
+			return errors.New("reload_cmd 与 reload_argv 不能同时设置")
+		}
+		t.ReloadCmd = formatArgv(t.ReloadArgv)
+	}
+	if err := t.setupReloadTarget(); err != nil {
+		return err
+	}
+
+	var err error
+	if t.checkTimeout, err = parseDurationOption("check_timeout", t.CheckTimeout); err != nil {
+		return err
+	}
+	if t.reloadTimeout, err = parseDurationOption("reload_timeout", t.ReloadTimeout); err != nil {
+		return err
+	}
+	if t.hookTimeout, err = parseDurationOption("hook_timeout", t.HookTimeout); err != nil {
+		return err
+	}
+	if t.runAs, err = lookupCredential(t.RunAs); err != nil {
+		return err
+	}
+	t.status = &resourceStatus{}
+	return nil
+}
+
+// commandEnv 返回传给命令的 CONFD_* 环境变量，stage 为空表示没有暂存文件
+func (t *TemplateResource) commandEnv(stage string) []string {
+	return []string{
+		"CONFD_SRC=" + t.Src,
+		"CONFD_DEST=" + t.Dest,
+		"CONFD_STAGE=" + stage,
+		"CONFD_RESOURCE=" + t.resourcePath,
+	}
+}
+
+// checkCommand 返回针对当前暂存文件的 check 命令，{{.src}} 替换为暂存文件路径
+func (t *TemplateResource) checkCommand() (*command, error) {
+	stage := t.StageFile.Name()
+	data := map[string]string{"src": stage}
+	c := &command{name: "check", timeout: t.checkTimeout, env: t.commandEnv(stage), runAs: t.runAs}
+	if len(t.CheckArgv) > 0 {
+		for _, a := range t.CheckArgv {
+			arg, err := renderCommand("checkcmd", a, data)
+			if err != nil {
+				return nil, err
+			}
+			c.argv = append(c.argv, arg)
+		}
+		return c, nil
+	}
+	shell, err := renderCommand("checkcmd", t.CheckCmd, data)
+	if err != nil {
+		return nil, err
+	}
+	c.shell = shell
+	return c, nil
+}
+
+// reloadCommand 返回 reload 命令，reload_signal 和 reload_systemd_unit 在进程内执行
+func (t *TemplateResource) reloadCommand() *command {
+	c := &command{name: "reload", timeout: t.reloadTimeout, env: t.commandEnv(""), runAs: t.runAs}
+	if len(t.ReloadArgv) > 0 {
+		c.argv = t.ReloadArgv
+	} else {
+		c.shell = t.ReloadCmd
+		c.fn = t.nativeReload()
+	}
+	return c
+}
+
+// healthCheckCommand 返回 health_check_cmd 对应的命令，使用 check_timeout
+func (t *TemplateResource) healthCheckCommand() *command {
+	return &command{name: "health_check", shell: t.HealthCheckCmd, timeout: t.checkTimeout, env: t.commandEnv(""), runAs: t.runAs}
+}
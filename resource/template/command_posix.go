@@ -0,0 +1,73 @@
+//go:build !windows
+// +build !windows
+
+package template
+
+import (
+	"fmt"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// credential 是 run_as 解析出的用户和组
+type credential struct {
+	uid uint32
+	gid uint32
+}
+
+func shellCommand(cmd string) *exec.Cmd {
+	return exec.Command("/bin/sh", "-c", cmd)
+}
+
+// prepareCommand 让命令在新的进程组中运行，以便超时后结束其所有子进程
+func prepareCommand(cmd *exec.Cmd, cred *credential) error {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if cred != nil {
+		cmd.SysProcAttr.Credential = &syscall.Credential{Uid: cred.uid, Gid: cred.gid}
+	}
+	return nil
+}
+
+// killCommand 结束命令所在的整个进程组
+func killCommand(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+// lookupCredential 解析 run_as，格式为 user、user:group 或 :group，也可以使用数字 ID。
+// 只指定用户时使用该用户的主组
+func lookupCredential(spec string) (*credential, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	name, group := spec, ""
+	if i := strings.IndexByte(spec, ':'); i >= 0 {
+		name, group = spec[:i], spec[i+1:]
+	}
+
+	cred := &credential{uid: uint32(syscall.Getuid()), gid: uint32(syscall.Getgid())}
+	if name != "" {
+		u, err := user.Lookup(name)
+		if err != nil {
+			if u, err = user.LookupId(name); err != nil {
+				return nil, fmt.Errorf("run_as 中的用户 %s 不存在", name)
+			}
+		}
+		uid, _ := strconv.ParseUint(u.Uid, 10, 32)
+		gid, _ := strconv.ParseUint(u.Gid, 10, 32)
+		cred.uid, cred.gid = uint32(uid), uint32(gid)
+	}
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			if g, err = user.LookupGroupId(group); err != nil {
+				return nil, fmt.Errorf("run_as 中的组 %s 不存在", group)
+			}
+		}
+		gid, _ := strconv.ParseUint(g.Gid, 10, 32)
+		cred.gid = uint32(gid)
+	}
+	return cred, nil
+}
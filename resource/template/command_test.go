@@ -0,0 +1,57 @@
+package template
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatusRecordsFailedCheck(t *testing.T) {
+	e := newTestEnv(t, map[string]string{"/a": "1"})
+	e.writeTemplate("t.tmpl", `{{getv "/a"}}`)
+	e.writeResource("t.toml", "[template]\nsrc = \"t.tmpl\"\ndest = \"{{out}}/t.conf\"\ncheck_cmd = \"echo bad config; exit 3\"\n")
+	r := e.resource("t.toml")
+	if err := r.process(); err == nil {
+		t.Fatal("process succeeded, want check_cmd failure")
+	}
+
+	st := r.Status()
+	if st.Check == nil {
+		t.Fatal("Status().Check = nil")
+	}
+	if st.Check.ExitCode != 3 || strings.TrimSpace(st.Check.Output) != "bad config" || st.Check.TimedOut {
+		t.Errorf("Status().Check = %+v", st.Check)
+	}
+	if st.Reload != nil {
+		t.Errorf("Status().Reload = %+v, want nil", st.Reload)
+	}
+	if s := st.Check.String(); !strings.Contains(s, "退出码 3") || !strings.Contains(s, "bad config") {
+		t.Errorf("String() = %q", s)
+	}
+}
+
+func TestNativeReloadTimeout(t *testing.T) {
+	c := &command{name: "reload", shell: "systemctl reload app", timeout: 50 * time.Millisecond, fn: func() (string, error) {
+		time.Sleep(time.Second)
+		return "", nil
+	}}
+	res, err := c.run()
+	if err == nil || !strings.Contains(err.Error(), "执行超时") {
+		t.Fatalf("error = %v, want timeout", err)
+	}
+	if !res.TimedOut || res.Duration >= time.Second {
+		t.Errorf("result = %+v, want timed out after 50ms", res)
+	}
+}
+
+func TestNativeReloadRejectsRunAs(t *testing.T) {
+	for _, target := range []string{"reload_systemd_unit = \"app.service\"", "reload_signal = \"HUP\"\npidfile = \"/run/app.pid\""} {
+		e := newTestEnv(t, nil)
+		e.writeTemplate("t.tmpl", "x")
+		p := e.writeResource("t.toml", "[template]\nsrc = \"t.tmpl\"\ndest = \"{{out}}/t.conf\"\nrun_as = \"nobody\"\n"+target+"\n")
+		_, err := NewTemplateResource(p, e.config)
+		if err == nil || !strings.Contains(err.Error(), "run_as") {
+			t.Errorf("%s: error = %v, want run_as rejected", target, err)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+//go:build windows
+// +build windows
+
+package template
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// credential 在 Windows 上不可用，run_as 会在加载资源时报错
+type credential struct{}
+
+func shellCommand(cmd string) *exec.Cmd {
+	return exec.Command("cmd", "/C", cmd)
+}
+
+func prepareCommand(cmd *exec.Cmd, cred *credential) error {
+	return nil
+}
+
+// killCommand 结束命令进程，Windows 上无法结束其子进程
+func killCommand(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+func lookupCredential(spec string) (*credential, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	return nil, errors.New("Windows 不支持 run_as")
+}
@@ -0,0 +1,72 @@
+package template
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/Risingtao/nacos-confd/log"
+)
+
+// destLocks 保证同一时刻只有一个资源在生成、替换或删除同一个目标文件
+var destLocks = &destLocker{locks: make(map[string]*sync.Mutex)}
+
+type destLocker struct {
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+// lock 按路径顺序锁定各目标文件，返回解锁函数。多个文件按固定顺序加锁以避免死锁
+func (l *destLocker) lock(dests ...string) func() {
+	paths := make([]string, 0, len(dests))
+	seen := make(map[string]bool, len(dests))
+	for _, d := range dests {
+		d = filepath.Clean(d)
+		if !seen[d] {
+			seen[d] = true
+			paths = append(paths, d)
+		}
+	}
+	sort.Strings(paths)
+
+	held := make([]*sync.Mutex, 0, len(paths))
+	for _, p := range paths {
+		l.mu.Lock()
+		m, ok := l.locks[p]
+		if !ok {
+			m = &sync.Mutex{}
+			l.locks[p] = m
+		}
+		l.mu.Unlock()
+		m.Lock()
+		held = append(held, m)
+	}
+	return func() {
+		for i := len(held) - 1; i >= 0; i-- {
+			held[i].Unlock()
+		}
+	}
+}
+
+// rejectDuplicateDests 检查多个资源是否声明了同一个 dest（模板化的 dest 按模板文本比较），
+// 重复的资源只保留按路径排序的第一个，其余的记录错误后跳过
+func rejectDuplicateDests(ts []*TemplateResource) ([]*TemplateResource, error) {
+	var lastErr error
+	owners := make(map[string]*TemplateResource, len(ts))
+	kept := make([]*TemplateResource, 0, len(ts))
+	for _, t := range ts {
+		dest := t.destTemplate
+		if dest == "" {
+			dest = filepath.Clean(t.Dest)
+		}
+		if first, ok := owners[dest]; ok {
+			lastErr = fmt.Errorf("%s 与 %s 声明了相同的 dest %s，已忽略 %s", t.resourcePath, first.resourcePath, dest, t.resourcePath)
+			log.Error("%v", lastErr)
+			continue
+		}
+		owners[dest] = t
+		kept = append(kept, t)
+	}
+	return kept, lastErr
+}
@@ -0,0 +1,104 @@
+package template
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Risingtao/nacos-confd/log"
+)
+
+// defaultStateDir 返回未设置 state_dir 时保存运行状态的目录
+func defaultStateDir(config Config) string {
+	if config.StateDir != "" {
+		return config.StateDir
+	}
+	return filepath.Join(config.ConfDir, "state")
+}
+
+// destStatePath 返回模板化 dest 的资源记录已生成文件的状态文件路径，
+// 文件名由资源文件相对 conf.d 的路径得到
+func destStatePath(config Config, resourcePath string) string {
+	name := resourcePath
+	if rel, err := filepath.Rel(config.ConfigDir, resourcePath); err == nil && !strings.HasPrefix(rel, "..") {
+		name = rel
+	}
+	name = strings.Replace(strings.TrimPrefix(filepath.ToSlash(name), "/"), "/", "_", -1)
+	return filepath.Join(defaultStateDir(config), name+".dests.json")
+}
+
+// loadRenderedDests 读取上一次运行时生成过的目标文件。状态文件不存在或无法解析时返回空集合，
+// 此时只能清理本进程生成过的文件
+func loadRenderedDests(statePath string) map[string]bool {
+	dests := make(map[string]bool)
+	data, err := ioutil.ReadFile(statePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warning("读取状态文件 %s 失败: %v", statePath, err)
+		}
+		return dests
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		log.Warning("无法解析状态文件 %s: %v", statePath, err)
+		return dests
+	}
+	for _, d := range list {
+		if filepath.IsAbs(d) {
+			dests[filepath.Clean(d)] = true
+		}
+	}
+	return dests
+}
+
+// setRenderedDests 更新已生成的目标文件集合，集合变化时写入状态文件，
+// 以便 confd 重启后仍能删除匹配项在停止期间消失的文件。noop 模式下不写入
+func (t *TemplateResource) setRenderedDests(dests map[string]bool) {
+	changed := len(dests) != len(t.renderedDests)
+	for d := range dests {
+		if !t.renderedDests[d] {
+			changed = true
+		}
+	}
+	t.renderedDests = dests
+	if !changed || t.noop || t.statePath == "" {
+		return
+	}
+	if err := saveRenderedDests(t.statePath, dests); err != nil {
+		log.Warning("写入状态文件 %s 失败: %v", t.statePath, err)
+	}
+}
+
+// saveRenderedDests 以排序后的 JSON 数组原子地写入状态文件
+func saveRenderedDests(statePath string, dests map[string]bool) error {
+	list := make([]string, 0, len(dests))
+	for d := range dests {
+		list = append(list, d)
+	}
+	sort.Strings(list)
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(statePath), 0755); err != nil {
+		return err
+	}
+	temp, err := ioutil.TempFile(filepath.Dir(statePath), "."+filepath.Base(statePath))
+	if err != nil {
+		return err
+	}
+	_, err = temp.Write(append(data, '\n'))
+	if cerr := temp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), statePath)
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+	}
+	return err
+}
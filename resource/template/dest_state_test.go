@@ -0,0 +1,123 @@
+package template
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestForeachStaleDestsSurviveRestart(t *testing.T) {
+	e := newTestEnv(t, map[string]string{"/sites/a": "A", "/sites/b": "B"})
+	e.writeTemplate("site.tmpl", "{{.Value}}\n")
+	e.writeResource("site.toml", `[template]
+src = "site.tmpl"
+dest = "{{out}}/{{.Name}}.conf"
+foreach = "/sites/*"
+keys = ["/sites/a", "/sites/b"]
+`)
+
+	first := e.resource("site.toml")
+	if err := first.process(); err != nil {
+		t.Fatal(err)
+	}
+	if got := e.outFiles(); !reflect.DeepEqual(got, []string{"a.conf", "b.conf"}) {
+		t.Fatalf("out = %v, want a.conf b.conf", got)
+	}
+	statePath := destStatePath(e.config, filepath.Join(e.config.ConfigDir, "site.toml"))
+	if _, err := os.Stat(statePath); err != nil {
+		t.Fatalf("state file not written: %v", err)
+	}
+
+	// 模拟 confd 停止期间 /sites/b 被删除，资源不再引用它，重启后重新加载资源
+	e.store.remove("/sites/b")
+	e.writeResource("site.toml", `[template]
+src = "site.tmpl"
+dest = "{{out}}/{{.Name}}.conf"
+foreach = "/sites/*"
+keys = ["/sites/a"]
+`)
+	second := e.resource("site.toml")
+	if err := second.process(); err != nil {
+		t.Fatal(err)
+	}
+	if got := e.outFiles(); !reflect.DeepEqual(got, []string{"a.conf"}) {
+		t.Errorf("out after restart = %v, want a.conf", got)
+	}
+	if got := loadRenderedDests(statePath); !reflect.DeepEqual(got, map[string]bool{filepath.Join(e.out, "a.conf"): true}) {
+		t.Errorf("state after restart = %v", got)
+	}
+}
+
+func TestForeachDestErrorKeepsExistingFile(t *testing.T) {
+	e := newTestEnv(t, map[string]string{"/sites/a": "A", "/sites/b": "B"})
+	e.writeTemplate("site.tmpl", "{{.Value}}\n")
+	// 值为 bad 的项 dest 渲染为空，渲染失败
+	e.writeResource("site.toml", `[template]
+src = "site.tmpl"
+dest = "{{if ne .Value \"bad\"}}{{out}}/{{.Name}}.conf{{end}}"
+foreach = "/sites/*"
+keys = ["/sites/a", "/sites/b"]
+`)
+	first := e.resource("site.toml")
+	if err := first.process(); err != nil {
+		t.Fatal(err)
+	}
+
+	e.store.set("/sites/a", "A2")
+	e.store.set("/sites/b", "bad")
+	if err := first.process(); err == nil {
+		t.Fatal("expected an error for the item whose dest failed to render")
+	}
+	if got := e.readOut("a.conf"); got != "A2\n" {
+		t.Errorf("a.conf = %q, want A2", got)
+	}
+	if got := e.readOut("b.conf"); got != "B\n" {
+		t.Errorf("b.conf = %q, want the previous content to survive", got)
+	}
+
+	// 之后删除该项时，之前生成的文件仍然会被清理
+	e.store.remove("/sites/b")
+	e.writeResource("site.toml", `[template]
+src = "site.tmpl"
+dest = "{{out}}/{{.Name}}.conf"
+foreach = "/sites/*"
+keys = ["/sites/a"]
+`)
+	if err := e.resource("site.toml").process(); err != nil {
+		t.Fatal(err)
+	}
+	if got := e.outFiles(); !reflect.DeepEqual(got, []string{"a.conf"}) {
+		t.Errorf("out = %v, want a.conf", got)
+	}
+}
+
+func TestLoadRenderedDests(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "x.dests.json")
+	if got := loadRenderedDests(p); len(got) != 0 {
+		t.Errorf("missing state file = %v, want empty", got)
+	}
+	if err := saveRenderedDests(p, map[string]bool{"/b": true, "/a/../a": true}); err != nil {
+		t.Fatal(err)
+	}
+	if got := loadRenderedDests(p); !reflect.DeepEqual(got, map[string]bool{"/a": true, "/b": true}) {
+		t.Errorf("loadRenderedDests = %v", got)
+	}
+	writeFile(t, p, `["relative", "/ok"]`)
+	if got := loadRenderedDests(p); !reflect.DeepEqual(got, map[string]bool{"/ok": true}) {
+		t.Errorf("relative paths should be ignored, got %v", got)
+	}
+	writeFile(t, p, `not json`)
+	if got := loadRenderedDests(p); len(got) != 0 {
+		t.Errorf("malformed state file = %v, want empty", got)
+	}
+}
+
+func writeFile(t *testing.T, p, content string) {
+	t.Helper()
+	if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,141 @@
+package template
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Risingtao/nacos-confd/log"
+	"github.com/fsnotify/fsnotify"
+)
+
+// dirWatchInterval 是无法使用系统的文件变化通知时扫描目录的间隔
+const dirWatchInterval = 2 * time.Second
+
+// dirWatcher 监视一组目录（含子目录）中文件的增删改，变化时向 Events 发送通知。
+// 通知不携带具体的文件，连续的变化可能合并为一次
+type dirWatcher struct {
+	events chan struct{}
+	done   chan struct{}
+	closer func() error
+}
+
+// Events 返回变化通知
+func (w *dirWatcher) Events() <-chan struct{} {
+	return w.events
+}
+
+// Close 停止监视
+func (w *dirWatcher) Close() error {
+	close(w.done)
+	if w.closer != nil {
+		return w.closer()
+	}
+	return nil
+}
+
+// newDirWatcher 通过 fsnotify 监视 dirs 及其子目录，新建的子目录会自动加入监视。
+// 系统不支持文件变化通知时退回到定期扫描
+func newDirWatcher(dirs ...string) *dirWatcher {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Warning("无法监视目录变化，改为每 %s 扫描一次目录: %v", dirWatchInterval, err)
+		return newPollingDirWatcher(dirs)
+	}
+	w := &dirWatcher{events: make(chan struct{}, 1), done: make(chan struct{}), closer: fw.Close}
+
+	// fsnotify 不会监视子目录，需要逐个加入
+	add := func(root string) {
+		filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+			if err != nil || !fi.IsDir() {
+				return nil
+			}
+			if err := fw.Add(path); err != nil {
+				log.Warning("无法监视目录 %s: %v", path, err)
+			}
+			return nil
+		})
+	}
+	for _, dir := range dirs {
+		add(dir)
+	}
+
+	go func() {
+		for {
+			select {
+			case ev, ok := <-fw.Events:
+				if !ok {
+					return
+				}
+				if ev.Op&fsnotify.Create != 0 {
+					if fi, err := os.Stat(ev.Name); err == nil && fi.IsDir() {
+						add(ev.Name)
+					}
+				}
+				w.notify()
+			case err, ok := <-fw.Errors:
+				if !ok {
+					return
+				}
+				log.Error("监视目录变化出错: %v", err)
+			}
+		}
+	}()
+	return w
+}
+
+func (w *dirWatcher) notify() {
+	select {
+	case w.events <- struct{}{}:
+	default:
+	}
+}
+
+// newPollingDirWatcher 通过定期比较目录中所有文件的修改时间和大小监视变化
+func newPollingDirWatcher(dirs []string) *dirWatcher {
+	w := &dirWatcher{events: make(chan struct{}, 1), done: make(chan struct{})}
+	go func() {
+		last := snapshotDirs(dirs)
+		ticker := time.NewTicker(dirWatchInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.done:
+				return
+			case <-ticker.C:
+				current := snapshotDirs(dirs)
+				if !sameSnapshot(last, current) {
+					w.notify()
+				}
+				last = current
+			}
+		}
+	}()
+	return w
+}
+
+func snapshotDirs(dirs []string) map[string]fileStamp {
+	snapshot := make(map[string]fileStamp)
+	for _, dir := range dirs {
+		filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			snapshot[path] = fileStamp{path: path, modTime: fi.ModTime(), size: fi.Size()}
+			return nil
+		})
+	}
+	return snapshot
+}
+
+func sameSnapshot(a, b map[string]fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for p, s := range a {
+		if t, ok := b[p]; !ok || !sameStamps([]fileStamp{s}, []fileStamp{t}) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package template
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileOwner 返回文件的属主和属组
+func fileOwner(fi os.FileInfo) (uid, gid int) {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return int(st.Uid), int(st.Gid)
+	}
+	return -1, -1
+}
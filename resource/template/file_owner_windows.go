@@ -0,0 +1,11 @@
+//go:build windows
+// +build windows
+
+package template
+
+import "os"
+
+// fileOwner 在 Windows 上没有 unix 属主和属组，总是返回 -1
+func fileOwner(fi os.FileInfo) (uid, gid int) {
+	return -1, -1
+}
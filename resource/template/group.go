@@ -0,0 +1,488 @@
+package template
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Risingtao/nacos-confd/depends/toml"
+	"github.com/Risingtao/nacos-confd/log"
+	"github.com/Risingtao/nacos-confd/util"
+)
+
+// resourceGroup 是 conf.d 中以 [group] 定义的资源组。同组资源的目标文件一起暂存、
+// 由组的 check_cmd 统一检查、一起替换，之后只执行一次组的 reload_cmd。
+type resourceGroup struct {
+	Name              string `toml:"name"`
+	CheckCmd          string `toml:"check_cmd"`
+	CheckInPlace      bool   `toml:"check_in_place"`
+	ReloadCmd         string `toml:"reload_cmd"`
+	HealthCheckCmd    string `toml:"health_check_cmd"`
+	ReloadDebounce    string `toml:"reload_debounce"`
+	ReloadMinInterval string `toml:"reload_min_interval"`
+	CheckTimeout      string `toml:"check_timeout"`
+	ReloadTimeout     string `toml:"reload_timeout"`
+	RunAs             string `toml:"run_as"`
+	reloadDebounce    time.Duration
+	reloadMinInterval time.Duration
+	checkTimeout      time.Duration
+	reloadTimeout     time.Duration
+	runAs             *credential
+	path              string
+	members           []*TemplateResource
+	mu                sync.Mutex
+}
+
+type resourceGroupConfig struct {
+	Group *resourceGroup `toml:"group"`
+}
+
+// loadConfFile 加载 conf.d 中的一个文件：文件中有 [group] 表时返回资源组，否则返回模板资源
+func loadConfFile(path string, config Config) (*TemplateResource, *resourceGroup, error) {
+	gc := &resourceGroupConfig{}
+	md, err := toml.DecodeFile(path, gc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("无法处理模板资源 %s - %s", path, err.Error())
+	}
+	if gc.Group == nil {
+		t, err := NewTemplateResource(path, config)
+		return t, nil, err
+	}
+	if md.IsDefined("template") {
+		return nil, nil, fmt.Errorf("%s 中不能同时定义 [group] 和 [template]", path)
+	}
+	if gc.Group.Name == "" {
+		return nil, nil, fmt.Errorf("%s 中的资源组缺少 name", path)
+	}
+	if gc.Group.reloadDebounce, gc.Group.reloadMinInterval, err = reloadTiming(config, gc.Group.ReloadDebounce, gc.Group.ReloadMinInterval); err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", path, err)
+	}
+	g := gc.Group
+	if g.checkTimeout, err = parseDurationOption("check_timeout", g.CheckTimeout); err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if g.reloadTimeout, err = parseDurationOption("reload_timeout", g.ReloadTimeout); err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if g.runAs, err = lookupCredential(g.RunAs); err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", path, err)
+	}
+	gc.Group.path = path
+	log.Debug("从 %s 加载资源组 %s", path, gc.Group.Name)
+	return nil, gc.Group, nil
+}
+
+// linkGroups 将资源与所属的资源组关联，返回可以处理的资源；
+// 引用了未定义资源组的资源会被跳过
+func linkGroups(ts []*TemplateResource, groups []*resourceGroup) ([]*TemplateResource, error) {
+	var lastErr error
+	byName := make(map[string]*resourceGroup, len(groups))
+	for _, g := range groups {
+		if prev, ok := byName[g.Name]; ok {
+			lastErr = fmt.Errorf("资源组 %s 在 %s 和 %s 中重复定义", g.Name, prev.path, g.path)
+			log.Error("%v", lastErr)
+			continue
+		}
+		byName[g.Name] = g
+	}
+
+	members := make(map[*resourceGroup][]*TemplateResource, len(byName))
+	linked := make([]*TemplateResource, 0, len(ts))
+	for _, t := range ts {
+		t.group = nil
+		if t.Group == "" {
+			linked = append(linked, t)
+			continue
+		}
+		g, ok := byName[t.Group]
+		if !ok {
+			lastErr = fmt.Errorf("模板 %s 引用了未定义的资源组 %s", t.Src, t.Group)
+			// 很可能是想设置目标文件的属组
+			if _, err := lookupGid(t.Group); err == nil {
+				lastErr = fmt.Errorf("%v；group 用于加入资源组，目标文件的属组请使用 file_group = %q", lastErr, t.Group)
+			}
+			log.Error("%v", lastErr)
+			continue
+		}
+		if t.ReloadCmd != "" || t.HealthCheckCmd != "" {
+			log.Warning("模板 %s 属于资源组 %s，其 reload_cmd 和 health_check_cmd 不会执行", t.Src, g.Name)
+		}
+		t.group = g
+		members[g] = append(members[g], t)
+		linked = append(linked, t)
+	}
+
+	// watch 模式下资源组可能正在被处理，需要持有组的锁更新成员
+	for _, g := range byName {
+		g.mu.Lock()
+		g.members = members[g]
+		g.mu.Unlock()
+	}
+	return linked, lastErr
+}
+
+// process 处理组内的所有资源。任一资源出错时整组都不会替换
+func (g *resourceGroup) process() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	txn := &groupTxn{group: g}
+	var lastErr error
+	errs := make([]error, len(g.members))
+	for i, t := range g.members {
+		txn.syncOnly = t.syncOnly
+		txn.keepStageFile = t.keepStageFile
+		t.txn = txn
+		errs[i] = t.process()
+		t.txn = nil
+		if errs[i] != nil {
+			log.Error("处理模板 %s 出错: %v", t.Src, errs[i])
+			lastErr = fmt.Errorf("模板 %s - 处理出错: %w", t.Src, errs[i])
+		}
+	}
+	if lastErr != nil {
+		txn.discard()
+		log.Warning("资源组 %s 中有资源处理失败，本轮不替换任何文件", g.Name)
+	} else {
+		lastErr = txn.commit()
+	}
+	// 暂存文件此时都已替换到目标位置或被删除
+	for _, p := range txn.pending {
+		stageFiles.done(p.staged)
+	}
+
+	// 成员的 on_error、on_unchanged 钩子以整组的结果为准
+	for i, t := range g.members {
+		err := errs[i]
+		if err == nil {
+			err = lastErr
+		}
+		t.finishCycle(err)
+	}
+	return lastErr
+}
+
+// pendingSync 是组内一个等待替换的目标文件
+type pendingSync struct {
+	t       *TemplateResource
+	dest    string
+	staged  string
+	mode    os.FileMode
+	digest  string
+	orig    *savedFile // 替换前的目标文件，为 nil 表示原先不存在
+	swapped bool
+}
+
+type savedFile struct {
+	data []byte
+	info util.FileInfo
+}
+
+// apply 在 t.Dest 和 t.FileMode 临时指向该文件的情况下执行 fn
+func (p *pendingSync) apply(fn func() error) error {
+	t := p.t
+	dest, mode := t.Dest, t.FileMode
+	t.Dest, t.FileMode = p.dest, p.mode
+	defer func() {
+		t.Dest, t.FileMode = dest, mode
+	}()
+	return fn()
+}
+
+// groupTxn 收集一轮处理中资源组内所有需要替换的文件
+type groupTxn struct {
+	group         *resourceGroup
+	pending       []*pendingSync
+	removed       bool // 是否有 foreach 展开的文件被删除，需要重新加载
+	syncOnly      bool
+	keepStageFile bool
+}
+
+// queue 记录 t 当前的暂存文件，等待整组一起替换。资源自身的 check_cmd 在此时执行
+func (x *groupTxn) queue(t *TemplateResource, staged string) error {
+	if !t.syncOnly && t.CheckCmd != "" {
+		if err := t.check(); err != nil {
+			return fmt.Errorf("配置检查失败: %v", err)
+		}
+	}
+	x.pending = append(x.pending, &pendingSync{t: t, dest: t.Dest, staged: staged, mode: t.FileMode})
+	return nil
+}
+
+// pendingFor 返回 dest 对应的等待替换项，x 为 nil 或没有时返回 nil
+func (x *groupTxn) pendingFor(dest string) *pendingSync {
+	if x == nil {
+		return nil
+	}
+	for _, p := range x.pending {
+		if p.dest == dest {
+			return p
+		}
+	}
+	return nil
+}
+
+// commit 检查并替换所有暂存文件，然后执行一次组的 reload_cmd 和 health_check_cmd。
+// 替换或检查失败时恢复已替换的文件；重新加载失败时回滚整组文件并再次重新加载。
+func (x *groupTxn) commit() error {
+	g := x.group
+	if len(x.pending) == 0 && !x.removed {
+		log.Debug("资源组 %s 已同步", g.Name)
+		return nil
+	}
+	defer destLocks.lock(x.dests()...)()
+
+	if !x.syncOnly && g.CheckCmd != "" && !g.CheckInPlace {
+		if err := x.check(false); err != nil {
+			x.discard()
+			return fmt.Errorf("资源组 %s 配置检查失败: %v", g.Name, err)
+		}
+	}
+
+	if err := x.swap(); err != nil {
+		x.restore()
+		x.discard()
+		return fmt.Errorf("资源组 %s 替换文件失败，已恢复: %v", g.Name, err)
+	}
+
+	if !x.syncOnly && g.CheckCmd != "" && g.CheckInPlace {
+		if err := x.check(true); err != nil {
+			if rerr := x.restore(); rerr != nil {
+				return fmt.Errorf("资源组 %s 配置检查失败: %v，恢复文件也失败: %v", g.Name, err, rerr)
+			}
+			return fmt.Errorf("资源组 %s 配置检查失败，已恢复: %v", g.Name, err)
+		}
+	}
+
+	rerun := func() error {
+		_, err := x.command("reload", g.ReloadCmd).run()
+		return err
+	}
+	if !x.syncOnly && g.ReloadCmd != "" && (g.reloadDebounce > 0 || g.reloadMinInterval > 0) {
+		// 合并执行时 reload 和健康检查的结果在之后的回调中处理
+		x.scheduleReload()
+	} else {
+		if !x.syncOnly && g.ReloadCmd != "" {
+			log.Info("执行资源组 %s 的reload脚本: %s", g.Name, g.ReloadCmd)
+			if err := rerun(); err != nil {
+				return x.rollback(fmt.Errorf("重新加载配置失败: %v", err), rerun)
+			}
+		}
+
+		if !x.syncOnly && g.HealthCheckCmd != "" {
+			log.Debug("执行资源组 %s 的健康检查: %s", g.Name, g.HealthCheckCmd)
+			if _, err := x.command("health_check", g.HealthCheckCmd).run(); err != nil {
+				return x.rollback(fmt.Errorf("健康检查失败: %v", err), rerun)
+			}
+		}
+	}
+
+	for _, p := range x.pending {
+		p.apply(func() error {
+			p.t.recordRender(p.digest)
+			log.Info("目标配置 %s 已更新", p.dest)
+			p.t.postSync()
+			if err := p.t.sendSyncNotification(); err != nil {
+				log.Warning("发送同步通知失败: %v", err)
+			}
+			return nil
+		})
+	}
+	return nil
+}
+
+// check 执行组的 check_cmd，{{.srcs}} 为以空格分隔的暂存文件（inPlace 时为已替换的目标文件），
+// {{.dests}} 为以空格分隔的目标文件
+func (x *groupTxn) check(inPlace bool) error {
+	srcs := make([]string, 0, len(x.pending))
+	dests := make([]string, 0, len(x.pending))
+	for _, p := range x.pending {
+		if inPlace {
+			srcs = append(srcs, p.dest)
+		} else {
+			srcs = append(srcs, p.staged)
+		}
+		dests = append(dests, p.dest)
+	}
+
+	data := map[string]string{
+		"srcs":  strings.Join(srcs, " "),
+		"dests": strings.Join(dests, " "),
+	}
+	shell, err := renderCommand("checkcmd", x.group.CheckCmd, data)
+	if err != nil {
+		return err
+	}
+	_, err = x.command("check", shell).run()
+	return err
+}
+
+// swap 依次替换各目标文件，替换前在内存中保存原文件以便恢复
+func (x *groupTxn) swap() error {
+	for _, p := range x.pending {
+		if util.IsFileExist(p.dest) {
+			info, err := util.FileStat(p.dest)
+			if err != nil {
+				return err
+			}
+			data, err := ioutil.ReadFile(p.dest)
+			if err != nil {
+				return err
+			}
+			p.orig = &savedFile{data: data, info: info}
+		}
+		err := p.apply(func() error {
+			if p.t.Backup > 0 {
+				if _, err := p.t.backupDest(); err != nil {
+					return err
+				}
+			}
+			log.Debug("正在覆盖目标配置 %s", p.dest)
+			return p.t.replaceConfig(p.staged)
+		})
+		if err != nil {
+			return err
+		}
+		p.swapped = true
+	}
+	return nil
+}
+
+// restore 将已替换的目标文件恢复为替换前的内容
+func (x *groupTxn) restore() error {
+	var errs []string
+	for i := len(x.pending) - 1; i >= 0; i-- {
+		p := x.pending[i]
+		if !p.swapped {
+			continue
+		}
+		var err error
+		if p.orig == nil {
+			err = os.Remove(p.dest)
+		} else {
+			err = writeFileAtomic(p.dest, p.orig.data, p.orig.info)
+		}
+		if err != nil {
+			log.Error("恢复 %s 失败: %v", p.dest, err)
+			errs = append(errs, fmt.Sprintf("%s: %v", p.dest, err))
+			continue
+		}
+		p.swapped = false
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// rollback 在重新加载或健康检查失败后恢复整组文件，用 rerun 再次执行 reload_cmd，并作为回滚事件发送通知
+func (x *groupTxn) rollback(cause error, rerun func() error) error {
+	g := x.group
+	dests := make([]string, 0, len(x.pending))
+	for _, p := range x.pending {
+		dests = append(dests, p.dest)
+	}
+	log.Error("资源组 %s 更新后失败，开始回滚: %v", g.Name, cause)
+
+	rerr := &RollbackError{Dest: strings.Join(dests, ", "), Cause: cause}
+	rerr.Err = x.restore()
+	if rerr.Err == nil && g.ReloadCmd != "" {
+		rerr.Err = rerun()
+	}
+	if rerr.Err != nil {
+		log.Error("%v", rerr)
+	} else {
+		log.Warning("资源组 %s 已回滚到上一版本", g.Name)
+	}
+	for _, p := range x.pending {
+		if err := p.t.notify(p.dest, "rollback", "配置回滚通知"); err != nil {
+			log.Warning("发送回滚通知失败: %v", err)
+		}
+	}
+	return rerr
+}
+
+// scheduleReload 将组的 reload_cmd 交给 reloads 合并执行，失败时回滚整组文件并再次安排 reload
+func (x *groupTxn) scheduleReload() {
+	g := x.group
+	reloadCmd := x.command("reload", g.ReloadCmd)
+	var healthCmd *command
+	if g.HealthCheckCmd != "" {
+		healthCmd = x.command("health_check", g.HealthCheckCmd)
+	}
+	reloads.schedule(reloadCmd, g.reloadDebounce, g.reloadMinInterval, func(_ *CommandResult, err error) {
+		if err != nil {
+			err = fmt.Errorf("重新加载配置失败: %v", err)
+		} else if healthCmd != nil {
+			if _, err = healthCmd.run(); err != nil {
+				err = fmt.Errorf("健康检查失败: %v", err)
+			}
+		}
+		if err == nil {
+			return
+		}
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		defer destLocks.lock(x.dests()...)()
+		x.rollback(err, func() error {
+			reloads.schedule(reloadCmd, g.reloadDebounce, g.reloadMinInterval, nil)
+			return nil
+		})
+	})
+}
+
+// command 返回组的命令。CONFD_DEST 和 CONFD_STAGE 为以空格分隔的各文件路径，
+// CONFD_RESOURCE 为定义资源组的文件
+func (x *groupTxn) command(name, shell string) *command {
+	g := x.group
+	dests := make([]string, 0, len(x.pending))
+	stages := make([]string, 0, len(x.pending))
+	for _, p := range x.pending {
+		dests = append(dests, p.dest)
+		if !p.swapped {
+			stages = append(stages, p.staged)
+		}
+	}
+	timeout := g.checkTimeout
+	if name == "reload" {
+		timeout = g.reloadTimeout
+	}
+	return &command{
+		name:    name,
+		shell:   shell,
+		timeout: timeout,
+		runAs:   g.runAs,
+		env: []string{
+			"CONFD_DEST=" + strings.Join(dests, " "),
+			"CONFD_STAGE=" + strings.Join(stages, " "),
+			"CONFD_RESOURCE=" + g.path,
+		},
+	}
+}
+
+// dests 返回所有等待替换的目标文件
+func (x *groupTxn) dests() []string {
+	dests := make([]string, 0, len(x.pending))
+	for _, p := range x.pending {
+		dests = append(dests, p.dest)
+	}
+	return dests
+}
+
+// discard 删除尚未替换的暂存文件
+func (x *groupTxn) discard() {
+	for _, p := range x.pending {
+		if p.swapped {
+			continue
+		}
+		if x.keepStageFile {
+			log.Info("保留暂存文件: %s", p.staged)
+			continue
+		}
+		os.Remove(p.staged)
+	}
+}
@@ -0,0 +1,113 @@
+package template
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newGroupEnv 创建资源组 web，成员 a.toml 和 b.toml 分别渲染 /a 和 /b，extra 追加到组的定义中
+func newGroupEnv(t *testing.T, extra string) *testEnv {
+	e := newTestEnv(t, map[string]string{"/a": "1", "/b": "1"})
+	e.writeTemplate("a.tmpl", `{{getv "/a"}}`)
+	e.writeTemplate("b.tmpl", `{{getv "/b"}}`)
+	e.writeResource("web.toml", "[group]\nname = \"web\"\n"+extra)
+	e.writeResource("a.toml", "[template]\nsrc = \"a.tmpl\"\ndest = \"{{out}}/a.conf\"\nkeys = [\"/a\"]\ngroup = \"web\"\n")
+	e.writeResource("b.toml", "[template]\nsrc = \"b.tmpl\"\ndest = \"{{out}}/b.conf\"\nkeys = [\"/b\"]\ngroup = \"web\"\n"+
+		"check_cmd = \"! grep -q bad {{.src}}\"\n")
+	return e
+}
+
+// processAll 加载 conf.d 中的资源并处理一轮
+func (e *testEnv) processAll() error {
+	ts, err := loadTemplateResources(e.config, nil)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	return process(context.Background(), ts, 2)
+}
+
+func TestGroupCommitsAllOrNothing(t *testing.T) {
+	e := newGroupEnv(t, "")
+	if err := e.processAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	// b 的 check_cmd 失败时 a 也不替换，暂存文件全部删除
+	e.store.set("/a", "2")
+	e.store.set("/b", "bad")
+	if err := e.processAll(); err == nil || !strings.Contains(err.Error(), "资源组 web") {
+		t.Fatalf("error = %v, want the group to fail", err)
+	}
+	if a, b := e.readOut("a.conf"), e.readOut("b.conf"); a != "1" || b != "1" {
+		t.Errorf("a.conf = %q, b.conf = %q; want both unchanged", a, b)
+	}
+	if got := e.outFiles(); !reflect.DeepEqual(got, []string{"a.conf", "b.conf"}) {
+		t.Errorf("out = %v, want no stage files left", got)
+	}
+
+	e.store.set("/b", "2")
+	if err := e.processAll(); err != nil {
+		t.Fatal(err)
+	}
+	if a, b := e.readOut("a.conf"), e.readOut("b.conf"); a != "2" || b != "2" {
+		t.Errorf("a.conf = %q, b.conf = %q; want both replaced", a, b)
+	}
+}
+
+func TestGroupCheckInPlaceRestoresMembers(t *testing.T) {
+	e := newGroupEnv(t, "check_cmd = \"! grep -q bad {{.srcs}}\"\ncheck_in_place = true\n")
+	// 成员自身不检查，由组在替换后检查
+	e.writeResource("b.toml", "[template]\nsrc = \"b.tmpl\"\ndest = \"{{out}}/b.conf\"\nkeys = [\"/b\"]\ngroup = \"web\"\nmode = \"0600\"\n")
+	if err := e.processAll(); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.Stat(filepath.Join(e.out, "b.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e.store.set("/a", "2")
+	e.store.set("/b", "bad")
+	if err := e.processAll(); err == nil || !strings.Contains(err.Error(), "已恢复") {
+		t.Fatalf("error = %v, want the group check to fail and restore", err)
+	}
+	if a, b := e.readOut("a.conf"), e.readOut("b.conf"); a != "1" || b != "1" {
+		t.Errorf("a.conf = %q, b.conf = %q; want both restored", a, b)
+	}
+	after, err := os.Stat(filepath.Join(e.out, "b.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Mode() != before.Mode() {
+		t.Errorf("b.conf mode = %v, want %v restored", after.Mode(), before.Mode())
+	}
+}
+
+// 引用了未定义资源组的资源被跳过，其余资源照常处理，处理器不会退出
+func TestUndefinedGroupDoesNotStopProcessor(t *testing.T) {
+	e := newProcessorEnv(t)
+	e.writeResource("u.toml", "[template]\nsrc = \"t.tmpl\"\ndest = \"{{out}}/u.conf\"\ngroup = \"nope\"\n")
+
+	errChan := make(chan error, 10)
+	stop := startProcessor(t, IntervalProcessor(e.config, errChan, 3600))
+	select {
+	case err := <-errChan:
+		if !strings.Contains(err.Error(), "未定义的资源组 nope") {
+			t.Errorf("error = %v, want the undefined group", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("load error was not reported")
+	}
+	waitFor(t, "有效的资源渲染", func() bool { return e.readOut("t.conf") == "1" })
+	if got := e.readOut("u.conf"); got != "" {
+		t.Errorf("u.conf = %q, want the resource to be skipped", got)
+	}
+	if err := stop(); err != nil {
+		t.Fatalf("Process = %v", err)
+	}
+}
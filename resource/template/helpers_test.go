@@ -0,0 +1,230 @@
+package template
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Risingtao/nacos-confd/backends"
+)
+
+// fakeStore 是内存中的 StoreClient。set 和 remove 会唤醒所有等待中的 WatchPrefix
+type fakeStore struct {
+	mu       sync.Mutex
+	values   map[string]string
+	index    uint64
+	changes  chan struct{}
+	gets     int
+	watching map[string]int // waitIndex 为 0 的 WatchPrefix 注册而尚未 Unwatch 的次数
+}
+
+func newFakeStore(values map[string]string) *fakeStore {
+	s := &fakeStore{values: make(map[string]string), index: 1, changes: make(chan struct{}), watching: make(map[string]int)}
+	for k, v := range values {
+		s.values[k] = v
+	}
+	return s
+}
+
+func (s *fakeStore) GetValues(keys []string) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gets++
+	result := make(map[string]string)
+	var missing []string
+	for _, k := range keys {
+		if v, ok := s.values[k]; ok {
+			result[k] = v
+		} else {
+			missing = append(missing, k)
+		}
+	}
+	if len(missing) > 0 {
+		return result, fmt.Errorf("%w: %s", backends.ErrKeyNotFound, strings.Join(missing, ", "))
+	}
+	return result, nil
+}
+
+func (s *fakeStore) WatchPrefix(ctx context.Context, prefix string, keys []string, waitIndex uint64) (uint64, error) {
+	if waitIndex == 0 {
+		s.mu.Lock()
+		for _, k := range keys {
+			s.watching[k]++
+		}
+		s.mu.Unlock()
+	}
+	for {
+		s.mu.Lock()
+		index, changes := s.index, s.changes
+		s.mu.Unlock()
+		if index > waitIndex {
+			return index, nil
+		}
+		select {
+		case <-changes:
+		case <-ctx.Done():
+			return waitIndex, ctx.Err()
+		}
+	}
+}
+
+func (s *fakeStore) Unwatch(keys []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range keys {
+		if s.watching[k]--; s.watching[k] <= 0 {
+			delete(s.watching, k)
+		}
+	}
+}
+
+// watchCount 返回 key 当前注册的监听次数
+func (s *fakeStore) watchCount(key string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.watching[key]
+}
+
+func (s *fakeStore) set(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+	s.changed()
+}
+
+// setQuiet 修改键值但不通知 WatchPrefix，模拟丢失的变更通知
+func (s *fakeStore) setQuiet(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+}
+
+func (s *fakeStore) remove(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+	s.changed()
+}
+
+func (s *fakeStore) changed() {
+	s.index++
+	close(s.changes)
+	s.changes = make(chan struct{})
+}
+
+func (s *fakeStore) getCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.gets
+}
+
+// testEnv 是一个临时的 confdir，包含 conf.d、templates 和存放目标文件的 out 目录
+type testEnv struct {
+	t      testing.TB
+	dir    string
+	out    string
+	store  *fakeStore
+	config Config
+}
+
+func newTestEnv(t testing.TB, values map[string]string) *testEnv {
+	dir, err := ioutil.TempDir("", "confd-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	e := &testEnv{t: t, dir: dir, out: filepath.Join(dir, "out"), store: newFakeStore(values)}
+	for _, d := range []string{"conf.d", "templates", "out"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e.config = Config{
+		ConfDir:     dir,
+		ConfigDir:   filepath.Join(dir, "conf.d"),
+		TemplateDir: filepath.Join(dir, "templates"),
+		StoreClient: e.store,
+		Prefix:      "/",
+	}
+	return e
+}
+
+// writeResource 在 conf.d 中写入资源文件，内容中的 {{out}} 替换为 out 目录
+func (e *testEnv) writeResource(name, content string) string {
+	return e.write(filepath.Join("conf.d", name), content)
+}
+
+func (e *testEnv) writeTemplate(name, content string) string {
+	return e.write(filepath.Join("templates", name), content)
+}
+
+func (e *testEnv) write(name, content string) string {
+	p := filepath.Join(e.dir, name)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		e.t.Fatal(err)
+	}
+	content = strings.Replace(content, "{{out}}", e.out, -1)
+	if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+		e.t.Fatal(err)
+	}
+	return p
+}
+
+// resource 加载 conf.d 中的资源文件
+func (e *testEnv) resource(name string) *TemplateResource {
+	t, err := NewTemplateResource(filepath.Join(e.dir, "conf.d", name), e.config)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	return t
+}
+
+// readOut 返回 out 目录中文件的内容，文件不存在时返回空字符串
+func (e *testEnv) readOut(name string) string {
+	data, err := ioutil.ReadFile(filepath.Join(e.out, name))
+	if err != nil && !os.IsNotExist(err) {
+		e.t.Fatal(err)
+	}
+	return string(data)
+}
+
+// outFiles 返回 out 目录中的所有文件名，包括暂存文件
+func (e *testEnv) outFiles() []string {
+	infos, err := ioutil.ReadDir(e.out)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range infos {
+		names = append(names, fi.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// benchResource 返回一个带片段和若干键的资源，用于渲染路径的基准测试
+func benchResource(b *testing.B) (*testEnv, *TemplateResource) {
+	values := make(map[string]string)
+	var tmpl strings.Builder
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("/app/key%d", i)
+		values[key] = fmt.Sprintf("value%d", i)
+		fmt.Fprintf(&tmpl, "key%d = {{getv %q}}\n", i, key)
+	}
+	tmpl.WriteString("{{range gets \"/app/*\"}}{{template \"line\" .}}{{end}}\n")
+	e := newTestEnv(b, values)
+	e.writeTemplate("_partials/line.tmpl", `{{define "line"}}{{.Key}}={{.Value}}{{"\n"}}{{end}}`)
+	e.writeTemplate("bench.tmpl", tmpl.String())
+	e.writeResource("bench.toml", "[template]\nsrc = \"bench.tmpl\"\ndest = \"{{out}}/bench.conf\"\n")
+	t := e.resource("bench.toml")
+	if err := t.setVars(); err != nil {
+		b.Fatal(err)
+	}
+	return e, t
+}
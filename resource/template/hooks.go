@@ -0,0 +1,59 @@
+package template
+
+import (
+	"github.com/Risingtao/nacos-confd/log"
+)
+
+// 资源支持的钩子
+const (
+	hookPreSync     = "pre_sync"     // 生成暂存文件之前，失败时本轮不再同步
+	hookPostSync    = "post_sync"    // 目标文件被替换之后
+	hookOnError     = "on_error"     // process 出错时，错误信息在 CONFD_ERROR 中
+	hookOnUnchanged = "on_unchanged" // 本轮处理没有发现任何变化时
+)
+
+// runHook 执行名为 name 的钩子命令，环境变量中额外包含 CONFD_HOOK 和 env。
+// Noop 模式下不执行钩子
+func (t *TemplateResource) runHook(name, cmd string, env ...string) error {
+	if cmd == "" || t.noop {
+		return nil
+	}
+	log.Debug("执行 %s 钩子: %s", name, cmd)
+	c := &command{
+		name:    name,
+		shell:   cmd,
+		timeout: t.hookTimeout,
+		env:     append(append(t.commandEnv(""), "CONFD_HOOK="+name), env...),
+		runAs:   t.runAs,
+	}
+	return t.runRecorded(c)
+}
+
+// postSync 在目标文件被替换之后执行 post_sync 钩子，钩子失败不影响本次同步
+func (t *TemplateResource) postSync() {
+	if err := t.runHook(hookPostSync, t.PostSyncCmd); err != nil {
+		log.Error("%s 的 post_sync 钩子执行失败: %v", t.Dest, err)
+	}
+}
+
+// onError 记录本轮失败的命令的结果，并执行 on_error 钩子
+func (t *TemplateResource) onError(err error) {
+	t.logFailedCommands()
+	if herr := t.runHook(hookOnError, t.OnErrorCmd, "CONFD_ERROR="+err.Error()); herr != nil {
+		log.Error("模板 %s 的 on_error 钩子执行失败: %v", t.Src, herr)
+	}
+}
+
+// finishCycle 在一轮处理结束后根据结果执行 on_error 或 on_unchanged 钩子
+func (t *TemplateResource) finishCycle(err error) {
+	if err != nil {
+		t.onError(err)
+		return
+	}
+	if t.cycleChanged {
+		return
+	}
+	if herr := t.runHook(hookOnUnchanged, t.OnUnchangedCmd); herr != nil {
+		log.Error("模板 %s 的 on_unchanged 钩子执行失败: %v", t.Src, herr)
+	}
+}
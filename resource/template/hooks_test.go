@@ -0,0 +1,93 @@
+package template
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newHookEnv 创建一个每个钩子和命令都把名称追加到 out/log 的资源，extra 追加到资源的定义中
+func newHookEnv(t *testing.T, extra string) *testEnv {
+	e := newTestEnv(t, map[string]string{"/a": "1"})
+	e.writeTemplate("t.tmpl", `{{getv "/a"}}`)
+	e.writeResource("t.toml", "[template]\nsrc = \"t.tmpl\"\ndest = \"{{out}}/t.conf\"\nkeys = [\"/a\"]\n"+
+		"check_cmd = \"echo check >> {{out}}/log\"\n"+
+		"reload_cmd = \"echo reload >> {{out}}/log; ! grep -q bad {{out}}/t.conf\"\n"+
+		"post_sync = \"echo $CONFD_HOOK >> {{out}}/log\"\n"+
+		"on_error = \"echo $CONFD_HOOK >> {{out}}/log\"\n"+
+		"on_unchanged = \"echo $CONFD_HOOK >> {{out}}/log\"\n"+extra)
+	return e
+}
+
+// takeLog 返回 out/log 中的各行并清空它
+func (e *testEnv) takeLog() string {
+	lines := strings.Fields(e.readOut("log"))
+	e.write(filepath.Join("out", "log"), "")
+	return strings.Join(lines, " ")
+}
+
+func TestHookOrder(t *testing.T) {
+	e := newHookEnv(t, "pre_sync = \"echo $CONFD_HOOK >> {{out}}/log; ! grep -q bad {{out}}/pre\"\n")
+	tr := e.resource("t.toml")
+
+	steps := []struct {
+		name    string
+		change  func()
+		wantErr bool
+		wantLog string
+	}{
+		{name: "first sync", wantLog: "pre_sync check post_sync reload"},
+		{name: "unchanged", wantLog: "on_unchanged"},
+		{name: "reload fails", change: func() { e.store.set("/a", "bad") }, wantErr: true,
+			wantLog: "pre_sync check post_sync reload on_error"},
+		{name: "pre_sync fails", change: func() {
+			e.store.set("/a", "2")
+			e.write(filepath.Join("out", "pre"), "bad")
+		}, wantErr: true, wantLog: "pre_sync on_error"},
+	}
+	for _, s := range steps {
+		if s.change != nil {
+			s.change()
+		}
+		err := tr.process()
+		if (err != nil) != s.wantErr {
+			t.Fatalf("%s: process = %v, want error %v", s.name, err, s.wantErr)
+		}
+		if got := e.takeLog(); got != s.wantLog {
+			t.Errorf("%s: hooks = %q, want %q", s.name, got, s.wantLog)
+		}
+	}
+	// pre_sync 失败时不替换目标文件
+	if got := e.readOut("t.conf"); got != "bad" {
+		t.Errorf("t.conf = %q, want it unchanged after pre_sync failed", got)
+	}
+}
+
+func TestHookEnvironment(t *testing.T) {
+	e := newTestEnv(t, map[string]string{"/a": "1"})
+	e.writeTemplate("t.tmpl", `{{getv "/a"}}`)
+	env := `echo \"$CONFD_HOOK|$CONFD_SRC|$CONFD_DEST|$CONFD_RESOURCE|$CONFD_ERROR\" >> {{out}}/env`
+	res := e.writeResource("t.toml", "[template]\nsrc = \"t.tmpl\"\ndest = \"{{out}}/t.conf\"\nkeys = [\"/a\"]\n"+
+		"reload_cmd = \"! grep -q bad {{out}}/t.conf\"\n"+
+		"post_sync = \""+env+"\"\non_error = \""+env+"\"\n")
+	tr := e.resource("t.toml")
+	if err := tr.process(); err != nil {
+		t.Fatal(err)
+	}
+	e.store.set("/a", "bad")
+	err := tr.process()
+	if err == nil {
+		t.Fatal("process succeeded, want the reload to fail")
+	}
+
+	src := filepath.Join(e.config.TemplateDir, "t.tmpl")
+	dest := filepath.Join(e.out, "t.conf")
+	want := []string{
+		strings.Join([]string{"post_sync", src, dest, res, ""}, "|"),
+		strings.Join([]string{"post_sync", src, dest, res, ""}, "|"),
+		strings.Join([]string{"on_error", src, dest, res, err.Error()}, "|"),
+	}
+	if got := strings.Split(strings.TrimSuffix(e.readOut("env"), "\n"), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("hook environment =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
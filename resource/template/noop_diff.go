@@ -0,0 +1,326 @@
+package template
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Risingtao/nacos-confd/log"
+	"github.com/Risingtao/nacos-confd/util"
+)
+
+const (
+	// diffContext 是 diff 中每个变化块前后保留的上下文行数
+	diffContext = 3
+	// maxDiffCells 限制计算 diff 时比较的行对数量，超出时把整个文件视为替换
+	maxDiffCells = 4 << 20
+	// secretMask 用于替换 diff 中通过 cget* 解密得到的值
+	secretMask = "******"
+)
+
+var (
+	// noopChanges 记录 Noop 模式下发现的待变更目标文件数量
+	noopChanges int64
+	// noopOutput 保证多个资源的 diff 不会交错输出
+	noopOutput sync.Mutex
+)
+
+// NoopChanges 返回 Noop 模式下发现的待变更目标文件数量
+func NoopChanges() int {
+	return int(atomic.LoadInt64(&noopChanges))
+}
+
+// reportNoopChange 输出 dest 与暂存文件之间的 unified diff 以及权限和所有者的变化，
+// 其中通过 cget* 解密得到的值被替换为 ******
+func (t *TemplateResource) reportNoopChange(staged string) {
+	atomic.AddInt64(&noopChanges, 1)
+
+	var out strings.Builder
+	newData, err := ioutil.ReadFile(staged)
+	if err != nil {
+		log.Error("读取暂存文件 %s 失败: %v", staged, err)
+		return
+	}
+	var oldData []byte
+	exists := util.IsFileExist(t.Dest)
+	if exists {
+		if oldData, err = ioutil.ReadFile(t.Dest); err != nil {
+			log.Error("读取目标配置 %s 失败: %v", t.Dest, err)
+			return
+		}
+	} else {
+		fmt.Fprintf(&out, "将创建 %s (权限 %04o, 所有者 %d:%d)\n", t.Dest, t.FileMode.Perm(), t.Uid, t.Gid)
+	}
+
+	if exists {
+		oldStat, err1 := util.FileStat(t.Dest)
+		newStat, err2 := util.FileStat(staged)
+		if err1 == nil && err2 == nil {
+			if oldStat.Mode != newStat.Mode {
+				fmt.Fprintf(&out, "%s 的权限将从 %04o 变为 %04o\n", t.Dest, oldStat.Mode.Perm(), newStat.Mode.Perm())
+			}
+			if oldStat.Uid != newStat.Uid || oldStat.Gid != newStat.Gid {
+				fmt.Fprintf(&out, "%s 的所有者将从 %d:%d 变为 %d:%d\n", t.Dest, oldStat.Uid, oldStat.Gid, newStat.Uid, newStat.Gid)
+			}
+		}
+	}
+
+	if !bytes.Equal(oldData, newData) {
+		if bytes.IndexByte(oldData, 0) >= 0 || bytes.IndexByte(newData, 0) >= 0 {
+			fmt.Fprintf(&out, "二进制文件 %s 不同\n", t.Dest)
+		} else {
+			oldName := t.Dest
+			if !exists {
+				oldName = os.DevNull
+			}
+			newLines := splitLines(string(newData))
+			m := newSecretMasker(t.secretValues(), newLines)
+			out.WriteString(unifiedDiff(oldName, t.Dest, splitLines(string(oldData)), newLines, m.mask))
+		}
+	}
+
+	noopOutput.Lock()
+	defer noopOutput.Unlock()
+	fmt.Fprint(os.Stdout, out.String())
+}
+
+// reportNoopRemoval 记录 Noop 模式下将被删除的目标文件
+func reportNoopRemoval(dest string) {
+	atomic.AddInt64(&noopChanges, 1)
+	noopOutput.Lock()
+	defer noopOutput.Unlock()
+	fmt.Fprintf(os.Stdout, "将删除 %s\n", dest)
+}
+
+// recordSecret 记录一次渲染中通过 cget* 解密得到的值
+func (t *TemplateResource) recordSecret(value string) {
+	if value == "" {
+		return
+	}
+	if t.secrets == nil {
+		t.secrets = make(map[string]bool)
+	}
+	t.secrets[value] = true
+}
+
+func (t *TemplateResource) resetSecrets() {
+	t.secrets = nil
+}
+
+// secretValues 返回本次渲染解密得到的值，按长度从长到短排列，避免短值先替换破坏长值
+func (t *TemplateResource) secretValues() []string {
+	values := make([]string, 0, len(t.secrets))
+	for v := range t.secrets {
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	return values
+}
+
+// secretMasker 隐藏 diff 中的敏感值。新文件中的敏感值可以直接识别；目标文件中可能是轮换前的旧值，
+// 因此对新文件中含有敏感值的行记录其前缀和后缀，目标文件中前后缀相同的行的中间部分也被隐藏
+type secretMasker struct {
+	secrets  []string
+	patterns []secretPattern
+}
+
+type secretPattern struct {
+	prefix, suffix string
+}
+
+func newSecretMasker(secrets []string, lines []string) *secretMasker {
+	m := &secretMasker{secrets: secrets}
+	for _, line := range lines {
+		for _, s := range secrets {
+			if i := strings.Index(line, s); i >= 0 {
+				p := secretPattern{prefix: line[:i], suffix: line[i+len(s):]}
+				// 没有前缀的行无法可靠地对应到旧的值
+				if strings.TrimSpace(p.prefix) != "" {
+					m.patterns = append(m.patterns, p)
+				}
+				break
+			}
+		}
+	}
+	return m
+}
+
+func (m *secretMasker) mask(line string) string {
+	if len(m.secrets) == 0 {
+		return line
+	}
+	for _, s := range m.secrets {
+		line = strings.Replace(line, s, secretMask, -1)
+	}
+	for _, p := range m.patterns {
+		if len(line) > len(p.prefix)+len(p.suffix) && strings.HasPrefix(line, p.prefix) && strings.HasSuffix(line, p.suffix) {
+			return p.prefix + secretMask + p.suffix
+		}
+	}
+	return line
+}
+
+// splitLines 按行拆分文本，不以换行结尾的最后一行带有 noNewline 标记
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	} else {
+		lines[len(lines)-1] += noNewline
+	}
+	return lines
+}
+
+const noNewline = "\n\\ No newline at end of file\n"
+
+type diffOp struct {
+	kind byte // ' '、'-' 或 '+'
+	line string
+}
+
+// diffLines 基于最长公共子序列计算逐行的差异，行数过多时退化为整体替换
+func diffLines(a, b []string) []diffOp {
+	if len(a)*len(b) > maxDiffCells {
+		ops := make([]diffOp, 0, len(a)+len(b))
+		for _, l := range a {
+			ops = append(ops, diffOp{'-', l})
+		}
+		for _, l := range b {
+			ops = append(ops, diffOp{'+', l})
+		}
+		return ops
+	}
+
+	// lcs[i][j] 为 a[i:] 与 b[j:] 的最长公共子序列长度
+	lcs := make([][]int32, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+	return ops
+}
+
+// unifiedDiff 返回 a 与 b 的 unified diff，没有差异时返回空字符串。差异按原始内容计算，
+// 输出的每一行经过 mask 处理，因此只有敏感值变化的行仍会显示为变化
+func unifiedDiff(aName, bName string, a, b []string, mask func(string) string) string {
+	ops := diffLines(a, b)
+	var out strings.Builder
+	// aLine、bLine 为 ops[k] 之前已经过的两侧行数
+	aLine, bLine := 0, 0
+	for k := 0; k < len(ops); {
+		if ops[k].kind == ' ' {
+			aLine++
+			bLine++
+			k++
+			continue
+		}
+
+		// 找到这个变化块的范围：相邻变化之间的相同行不超过 2*diffContext 时合并为一块
+		start := k - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := k
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			run := end
+			for run < len(ops) && ops[run].kind == ' ' {
+				run++
+			}
+			if run == len(ops) || run-end > 2*diffContext {
+				if run-end < diffContext {
+					end = run
+				} else {
+					end += diffContext
+				}
+				break
+			}
+			end = run
+		}
+
+		aStart, bStart := aLine-(k-start), bLine-(k-start)
+		aCount, bCount := 0, 0
+		for _, op := range ops[start:end] {
+			if op.kind != '+' {
+				aCount++
+			}
+			if op.kind != '-' {
+				bCount++
+			}
+		}
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", aName, bName)
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(aStart, aCount), hunkRange(bStart, bCount))
+		for _, op := range ops[start:end] {
+			out.WriteByte(op.kind)
+			out.WriteString(mask(op.line))
+		}
+
+		for _, op := range ops[k:end] {
+			if op.kind != '+' {
+				aLine++
+			}
+			if op.kind != '-' {
+				bLine++
+			}
+		}
+		k = end
+	}
+	return out.String()
+}
+
+// hunkRange 按 unified diff 的约定格式化起始行号和行数
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
@@ -0,0 +1,153 @@
+package template
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+// numberedLines 返回 "1\n" 到 "n\n"，changes 中的行替换为给定内容
+func numberedLines(n int, changes map[int]string) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("%d\n", i+1)
+		if c, ok := changes[i+1]; ok {
+			lines[i] = c + "\n"
+		}
+	}
+	return lines
+}
+
+func noMask(line string) string { return line }
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name         string
+		aName, bName string
+		a, b         []string
+		want         string
+	}{
+		{
+			name: "unchanged",
+			a:    splitLines("a\nb\n"), b: splitLines("a\nb\n"),
+			want: "",
+		},
+		{
+			name: "single line",
+			a:    splitLines("a\nb\nc\n"), b: splitLines("a\nB\nc\n"),
+			want: "--- old\n+++ new\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+		},
+		{
+			// 两处变化之间相同的行不超过 2*diffContext，合并为一块
+			name: "merged hunks",
+			a:    numberedLines(10, nil), b: numberedLines(10, map[int]string{2: "two", 8: "eight"}),
+			want: "--- old\n+++ new\n@@ -1,10 +1,10 @@\n 1\n-2\n+two\n 3\n 4\n 5\n 6\n 7\n-8\n+eight\n 9\n 10\n",
+		},
+		{
+			name: "separate hunks",
+			a:    numberedLines(20, nil), b: numberedLines(20, map[int]string{2: "two", 15: "fifteen"}),
+			want: "--- old\n+++ new\n@@ -1,5 +1,5 @@\n 1\n-2\n+two\n 3\n 4\n 5\n" +
+				"@@ -12,7 +12,7 @@\n 12\n 13\n 14\n-15\n+fifteen\n 16\n 17\n 18\n",
+		},
+		{
+			name:  "added file",
+			aName: os.DevNull,
+			a:     nil, b: splitLines("x\ny\n"),
+			want: "--- /dev/null\n+++ new\n@@ -0,0 +1,2 @@\n+x\n+y\n",
+		},
+		{
+			name:  "removed file",
+			bName: os.DevNull,
+			a:     splitLines("x\ny\n"), b: nil,
+			want: "--- old\n+++ /dev/null\n@@ -1,2 +0,0 @@\n-x\n-y\n",
+		},
+		{
+			name: "newline removed at end of file",
+			a:    splitLines("x\n"), b: splitLines("x"),
+			want: "--- old\n+++ new\n@@ -1 +1 @@\n-x\n+x\n\\ No newline at end of file\n",
+		},
+		{
+			name: "line appended after missing newline",
+			a:    splitLines("x"), b: splitLines("x\ny\n"),
+			want: "--- old\n+++ new\n@@ -1 +1,2 @@\n-x\n\\ No newline at end of file\n+x\n+y\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aName, bName := tt.aName, tt.bName
+			if aName == "" {
+				aName = "old"
+			}
+			if bName == "" {
+				bName = "new"
+			}
+			if got := unifiedDiff(aName, bName, tt.a, tt.b, noMask); got != tt.want {
+				t.Errorf("unifiedDiff =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// 比较的行对超过 maxDiffCells 时不计算最长公共子序列，整个文件视为替换
+func TestDiffLinesFallsBackToReplace(t *testing.T) {
+	n := 2049 // n*n 刚好超过 maxDiffCells
+	if n*n <= maxDiffCells {
+		t.Fatalf("%d lines do not exceed maxDiffCells", n)
+	}
+	a := numberedLines(n, nil)
+	b := numberedLines(n, map[int]string{1: "changed"})
+	ops := diffLines(a, b)
+	if len(ops) != 2*n {
+		t.Fatalf("len(ops) = %d, want %d", len(ops), 2*n)
+	}
+	for i, op := range ops {
+		want := byte('-')
+		if i >= n {
+			want = '+'
+		}
+		if op.kind != want {
+			t.Fatalf("ops[%d].kind = %q, want %q", i, op.kind, want)
+		}
+	}
+
+	// 未超过限制时只有变化的行
+	small := diffLines(a[:100], b[:100])
+	changed := 0
+	for _, op := range small {
+		if op.kind != ' ' {
+			changed++
+		}
+	}
+	if changed != 2 {
+		t.Errorf("changed lines = %d, want 2", changed)
+	}
+}
+
+func TestSecretMasking(t *testing.T) {
+	oldLines := splitLines("user = admin\npassword = old-secret\ntoken=old-token;\n")
+	newLines := splitLines("user = admin\npassword = new-secret\ntoken=new-token;\n")
+	m := newSecretMasker([]string{"new-secret", "new-token"}, newLines)
+	got := unifiedDiff("old", "new", oldLines, newLines, m.mask)
+
+	// 新值直接隐藏；轮换前的旧值按新值所在行的前后缀识别并隐藏，变化仍然显示
+	want := "--- old\n+++ new\n@@ -1,3 +1,3 @@\n user = admin\n" +
+		"-password = ******\n-token=******;\n+password = ******\n+token=******;\n"
+	if got != want {
+		t.Errorf("diff =\n%s\nwant\n%s", got, want)
+	}
+	for _, secret := range []string{"old-secret", "new-secret", "old-token", "new-token"} {
+		if strings.Contains(got, secret) {
+			t.Errorf("diff leaks %q", secret)
+		}
+	}
+
+	// 没有前缀的行无法对应到旧值，只隐藏新值本身
+	m = newSecretMasker([]string{"new-secret"}, splitLines("new-secret\n"))
+	if got := m.mask("other\n"); got != "other\n" {
+		t.Errorf("mask(other) = %q, want it unchanged", got)
+	}
+	if got := m.mask("new-secret\n"); got != "******\n" {
+		t.Errorf("mask(new-secret) = %q", got)
+	}
+}
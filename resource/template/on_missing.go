@@ -0,0 +1,153 @@
+package template
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Risingtao/nacos-confd/backends"
+	"github.com/Risingtao/nacos-confd/log"
+	"github.com/Risingtao/nacos-confd/util"
+)
+
+// on_missing 的取值，决定后端中的键不存在时如何处理目标文件
+const (
+	onMissingKeep   = "keep"
+	onMissingDelete = "delete"
+	onMissingEmpty  = "empty"
+	onMissingError  = "error"
+)
+
+// checkOnMissing 校验 on_missing 选项
+func (t *TemplateResource) checkOnMissing() error {
+	switch t.OnMissing {
+	case "", onMissingKeep, onMissingDelete, onMissingError:
+		return nil
+	case onMissingEmpty:
+		if t.destTemplate != "" {
+			return errors.New(`on_missing = "empty" 不能用于模板化的 dest`)
+		}
+		return nil
+	}
+	return fmt.Errorf("无效的 on_missing %q，可选值为 keep、delete、empty、error", t.OnMissing)
+}
+
+// getValues 从后端获取 keys 的值。后端用 ErrKeyNotFound 报告不存在的键时照常返回其余的值，
+// 不存在的键由 missingKeys 找出并按 on_missing 处理
+func (t *TemplateResource) getValues(keys []string) (map[string]string, error) {
+	result, err := t.storeClient.GetValues(keys)
+	if errors.Is(err, backends.ErrKeyNotFound) {
+		return result, nil
+	}
+	return result, err
+}
+
+// missingKeys 返回 keys 中在后端不存在的键：配置已被删除，或服务没有任何实例
+func missingKeys(keys []string, result map[string]string) []string {
+	var missing []string
+	for _, k := range keys {
+		v, ok := result[k]
+		if !ok || (isServiceKey(k) && strings.TrimSpace(v) == "[]") {
+			missing = append(missing, k)
+		}
+	}
+	return missing
+}
+
+// isServiceKey 报告 key 是否对应 nacos 中的服务，与后端的判断方式一致
+func isServiceKey(key string) bool {
+	k := strings.Replace(strings.TrimPrefix(key, "/"), "/", ".", -1)
+	return strings.HasPrefix(k, "naming.")
+}
+
+// handleMissing 按 on_missing 处理后端中不存在的键，返回 true 表示本轮不再渲染目标文件。
+// 未设置 on_missing 时与之前的行为一致：配置不存在时报错，服务没有实例时照常渲染
+func (t *TemplateResource) handleMissing(missing []string) (bool, error) {
+	t.renderEmpty = false
+	if t.OnMissing == "" {
+		var configs []string
+		for _, k := range missing {
+			if !isServiceKey(k) {
+				configs = append(configs, k)
+			}
+		}
+		missing = configs
+	}
+	if len(missing) == 0 {
+		return false, nil
+	}
+
+	keys := strings.Join(missing, ", ")
+	switch t.OnMissing {
+	case onMissingKeep:
+		log.Warning("后端中不存在键 %s，保留目标配置 %s", keys, t.Dest)
+		return true, nil
+	case onMissingDelete:
+		return true, t.deleteMissingDests(keys)
+	case onMissingEmpty:
+		log.Warning("后端中不存在键 %s，目标配置 %s 将被清空", keys, t.Dest)
+		t.renderEmpty = true
+		return false, nil
+	}
+	return true, fmt.Errorf("后端中不存在键: %s", keys)
+}
+
+// deleteMissingDests 删除资源的目标文件（模板化的 dest 为本进程生成过的所有文件），
+// 有文件被删除时执行 reload_cmd 并发送通知
+func (t *TemplateResource) deleteMissingDests(keys string) error {
+	removed := false
+	if t.destTemplate != "" {
+		current := make(map[string]bool)
+		removed = t.removeStaleDests(current)
+		t.setRenderedDests(current)
+		t.forgetRenders(current)
+	} else {
+		var err error
+		if removed, err = t.removeDest(); err != nil {
+			return err
+		}
+	}
+	if !removed {
+		return nil
+	}
+
+	t.cycleChanged = true
+	log.Info("后端中不存在键 %s，已删除目标配置", keys)
+	if err := t.sendNotification("delete", fmt.Sprintf("后端中不存在键 %s，目标配置已删除", keys)); err != nil {
+		log.Warning("发送删除通知失败: %v", err)
+	}
+	if t.txn != nil {
+		t.txn.removed = true
+		return nil
+	}
+	if !t.syncOnly && t.ReloadCmd != "" {
+		if err := t.requestReload(); err != nil {
+			return fmt.Errorf("重新加载配置失败: %v", err)
+		}
+	}
+	return nil
+}
+
+// removeDest 删除 t.Dest，设置了 backup 时先备份，返回文件是否被删除
+func (t *TemplateResource) removeDest() (bool, error) {
+	defer destLocks.lock(t.Dest)()
+	if !util.IsFileExist(t.Dest) {
+		return false, nil
+	}
+	if t.noop {
+		reportNoopRemoval(t.Dest)
+		log.Warning("Noop 模式已启用。%s 不会被删除", t.Dest)
+		return false, nil
+	}
+	if t.Backup > 0 {
+		if _, err := t.backupDest(); err != nil {
+			return false, err
+		}
+	}
+	if err := os.Remove(t.Dest); err != nil {
+		return false, fmt.Errorf("删除目标配置 %s 失败: %v", t.Dest, err)
+	}
+	delete(t.lastRender, t.Dest)
+	return true, nil
+}
@@ -0,0 +1,76 @@
+package template
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Risingtao/nacos-confd/util"
+)
+
+func TestOnMissing(t *testing.T) {
+	tests := []struct {
+		onMissing  string
+		wantExists bool
+		wantOut    string
+		wantReload bool
+		wantErr    string
+	}{
+		{onMissing: "keep", wantExists: true, wantOut: "a=1"},
+		{onMissing: "delete", wantReload: true},
+		{onMissing: "empty", wantExists: true, wantOut: "", wantReload: true},
+		{onMissing: "", wantExists: true, wantOut: "a=1", wantErr: "后端中不存在键: /a"},
+	}
+	for _, tt := range tests {
+		name := tt.onMissing
+		if name == "" {
+			name = "unset"
+		}
+		t.Run(name, func(t *testing.T) {
+			e := newTestEnv(t, map[string]string{"/a": "1"})
+			e.writeTemplate("t.tmpl", `a={{getv "/a"}}`)
+			e.writeResource("t.toml", "[template]\nsrc = \"t.tmpl\"\ndest = \"{{out}}/t.conf\"\nkeys = [\"/a\"]\n"+
+				"on_missing = \""+tt.onMissing+"\"\nreload_cmd = \"echo reload >> {{out}}/reloads\"\n")
+			tr := e.resource("t.toml")
+			if err := tr.process(); err != nil {
+				t.Fatal(err)
+			}
+			if got := e.readOut("reloads"); got != "reload\n" {
+				t.Fatalf("reloads = %q after the first render", got)
+			}
+
+			// 后端用 ErrKeyNotFound 报告不存在的键，由 on_missing 决定如何处理目标文件
+			e.store.remove("/a")
+			err := tr.process()
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("process = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("process = %v, want error containing %q", err, tt.wantErr)
+			}
+			dest := filepath.Join(e.out, "t.conf")
+			if got := util.IsFileExist(dest); got != tt.wantExists {
+				t.Fatalf("t.conf exists = %v, want %v", got, tt.wantExists)
+			}
+			if got := e.readOut("t.conf"); got != tt.wantOut {
+				t.Errorf("t.conf = %q, want %q", got, tt.wantOut)
+			}
+			wantReloads := "reload\n"
+			if tt.wantReload {
+				wantReloads += "reload\n"
+			}
+			if got := e.readOut("reloads"); got != wantReloads {
+				t.Errorf("reloads = %q, want %q", got, wantReloads)
+			}
+
+			// 键恢复后照常渲染
+			e.store.set("/a", "2")
+			if err := tr.process(); err != nil {
+				t.Fatal(err)
+			}
+			if got := e.readOut("t.conf"); got != "a=2" {
+				t.Errorf("t.conf = %q after the key is restored", got)
+			}
+		})
+	}
+}
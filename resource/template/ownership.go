@@ -0,0 +1,154 @@
+package template
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+
+	"github.com/Risingtao/nacos-confd/log"
+)
+
+// defaultDirMode 是 create_dirs 创建目录时默认使用的权限
+const defaultDirMode = 0755
+
+// resolveOwnership 将 owner、file_group 解析为 Uid、Gid，未设置时使用当前进程的有效用户和组，
+// 并解析 dir_mode
+func (t *TemplateResource) resolveOwnership() error {
+	if t.Owner != "" {
+		if t.Uid != -1 {
+			return errors.New("uid 与 owner 不能同时设置")
+		}
+		uid, err := lookupUid(t.Owner)
+		if err != nil {
+			return err
+		}
+		t.Uid = uid
+	}
+	if t.FileGroup != "" {
+		if t.Gid != -1 {
+			return errors.New("gid 与 file_group 不能同时设置")
+		}
+		gid, err := lookupGid(t.FileGroup)
+		if err != nil {
+			return err
+		}
+		t.Gid = gid
+	}
+
+	if t.Uid == -1 {
+		t.Uid = os.Geteuid()
+	}
+	if t.Gid == -1 {
+		t.Gid = os.Getegid()
+	}
+
+	t.dirMode = defaultDirMode
+	if t.DirMode != "" {
+		if !t.CreateDirs {
+			return errors.New("dir_mode 只能与 create_dirs 一起使用")
+		}
+		mode, err := strconv.ParseUint(t.DirMode, 0, 32)
+		if err != nil {
+			return fmt.Errorf("无效的 dir_mode %q: %v", t.DirMode, err)
+		}
+		t.dirMode = os.FileMode(mode)
+	}
+	return nil
+}
+
+// lookupUid 返回用户名对应的 uid，name 为数字时直接使用
+func lookupUid(name string) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, fmt.Errorf("无效的 owner %s: %v", name, err)
+	}
+	id, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return 0, fmt.Errorf("用户 %s 的 uid 不是数字: %s", name, u.Uid)
+	}
+	return id, nil
+}
+
+// lookupGid 返回组名对应的 gid，name 为数字时直接使用
+func lookupGid(name string) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, fmt.Errorf("无效的 file_group %s: %v", name, err)
+	}
+	id, err := strconv.Atoi(g.Gid)
+	if err != nil {
+		return 0, fmt.Errorf("组 %s 的 gid 不是数字: %s", name, g.Gid)
+	}
+	return id, nil
+}
+
+// stageDir 返回创建暂存文件的目录。暂存文件通常放在目标目录中以避免跨文件系统的重命名；
+// 目标目录不存在时，设置了 create_dirs 则按 dir_mode 创建缺失的各级目录并设置所有者，
+// Noop 模式下不创建目录，暂存文件改放在系统临时目录
+func (t *TemplateResource) stageDir() (string, error) {
+	dir := filepath.Dir(t.Dest)
+	if fi, err := os.Stat(dir); err == nil {
+		if !fi.IsDir() {
+			return "", fmt.Errorf("%s 不是目录", dir)
+		}
+		return dir, nil
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	if !t.CreateDirs {
+		return "", fmt.Errorf("目标目录 %s 不存在，可设置 create_dirs = true 自动创建", dir)
+	}
+	if t.noop {
+		log.Warning("Noop 模式已启用，不会创建目录 %s", dir)
+		return os.TempDir(), nil
+	}
+	if err := t.createDirs(dir); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// createDirs 从最上层缺失的目录开始逐级创建 dir，只有新建的目录才设置权限和所有者
+func (t *TemplateResource) createDirs(dir string) error {
+	var missing []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		missing = append(missing, d)
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+
+	for i := len(missing) - 1; i >= 0; i-- {
+		d := missing[i]
+		if err := os.Mkdir(d, t.dirMode); err != nil {
+			if os.IsExist(err) {
+				continue
+			}
+			return fmt.Errorf("创建目录 %s 失败: %v", d, err)
+		}
+		// Mkdir 受 umask 影响，需要再设置一次权限
+		if err := os.Chmod(d, t.dirMode); err != nil {
+			return err
+		}
+		if err := os.Chown(d, t.Uid, t.Gid); err != nil {
+			return fmt.Errorf("设置目录 %s 的所有者失败: %v", d, err)
+		}
+		log.Info("已创建目录 %s", d)
+	}
+	return nil
+}
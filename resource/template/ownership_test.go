@@ -0,0 +1,48 @@
+package template
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// group 仍然表示资源组，目标文件的属组使用 file_group
+func TestGroupAndFileGroup(t *testing.T) {
+	e := newTestEnv(t, map[string]string{"/a": "1"})
+	e.writeTemplate("t.tmpl", `{{getv "/a"}}`)
+	gid := strconv.Itoa(os.Getegid())
+	e.writeResource("web.toml", "[group]\nname = \"web\"\n")
+	e.writeResource("t.toml", "[template]\nsrc = \"t.tmpl\"\ndest = \"{{out}}/t.conf\"\ngroup = \"web\"\nfile_group = \""+gid+"\"\n")
+
+	ts, err := getTemplateResources(e.config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ts) != 1 || ts[0].group == nil || ts[0].group.Name != "web" {
+		t.Fatalf("resources = %+v, want one member of group web", ts)
+	}
+	if ts[0].Gid != os.Getegid() {
+		t.Errorf("Gid = %d, want %s", ts[0].Gid, gid)
+	}
+
+	// 把系统组名写在 group 中时资源被跳过，错误提示使用 file_group
+	g, err := user.LookupGroupId(gid)
+	if err != nil {
+		t.Skip(err)
+	}
+	e.writeResource("t.toml", "[template]\nsrc = \"t.tmpl\"\ndest = \"{{out}}/t.conf\"\ngroup = \""+g.Name+"\"\n")
+	ts, err = getTemplateResources(e.config)
+	if len(ts) != 0 {
+		t.Errorf("resources = %+v, want the resource to be skipped", ts)
+	}
+	if err == nil || !strings.Contains(err.Error(), "未定义的资源组 "+g.Name) || !strings.Contains(err.Error(), "file_group = \""+g.Name+"\"") {
+		t.Errorf("error = %v, want an undefined group error pointing to file_group", err)
+	}
+
+	p := e.writeResource("t.toml", "[template]\nsrc = \"t.tmpl\"\ndest = \"{{out}}/t.conf\"\ngid = 0\nfile_group = \""+gid+"\"\n")
+	if _, err := NewTemplateResource(p, e.config); err == nil || !strings.Contains(err.Error(), "file_group") {
+		t.Errorf("error = %v, want gid and file_group conflict", err)
+	}
+}
@@ -1,17 +1,23 @@
 package template
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"time"
 
+	"github.com/Risingtao/nacos-confd/backends"
 	"github.com/Risingtao/nacos-confd/log"
 	"github.com/Risingtao/nacos-confd/util"
 )
 
 // Processor 定义一个处理接口，所有处理器都需要实现这个接口
 type Processor interface {
-	Process()
+	// Process 持续处理模板资源，直到 ctx 取消。返回前等待正在进行的处理结束，
+	// 并执行所有等待合并的 reload；无法继续处理时返回错误
+	Process(ctx context.Context) error
+	// Resync 让处理器立即重新处理所有模板资源
+	Resync()
 }
 
 // Process 函数用于处理配置
@@ -21,121 +27,408 @@ func Process(config Config) error {
 	if err != nil {
 		return fmt.Errorf("获取模板资源出错: %w", err)
 	}
-	// 开始处理模板资源
-	return process(ts)
+	// 开始处理模板资源，退出前执行所有等待合并的 reload
+	defer reloads.flush()
+	return process(context.Background(), ts, config.Workers)
 }
 
-// process 函数依次处理所有模板资源
-func process(ts []*TemplateResource) error {
-	var lastErr error
+// process 函数用最多 workers 个 goroutine 并发处理所有模板资源，workers 小于 1 时按 1 处理。
+// ctx 取消后不再开始新的资源，已经开始的处理会完成
+func process(ctx context.Context, ts []*TemplateResource, workers int) error {
+	var jobs []func() error
+	done := make(map[*resourceGroup]bool)
 	for _, t := range ts {
-		if err := t.process(); err != nil {
-			log.Error("处理模板 %s 出错: %v", t.Src, err)
-			lastErr = fmt.Errorf("模板 %s - 处理出错: %w", t.Src, err)
+		t := t
+		if g := t.group; g != nil {
+			// 资源组在第一个成员的位置整体处理一次
+			if done[g] {
+				continue
+			}
+			done[g] = true
+			jobs = append(jobs, func() error {
+				if err := g.process(); err != nil {
+					log.Error("处理资源组 %s 出错: %v", g.Name, err)
+					return fmt.Errorf("资源组 %s - 处理出错: %w", g.Name, err)
+				}
+				return nil
+			})
+			continue
 		}
+		jobs = append(jobs, func() error {
+			if err := t.process(); err != nil {
+				log.Error("处理模板 %s 出错: %v", t.Src, err)
+				return fmt.Errorf("模板 %s - 处理出错: %w", t.Src, err)
+			}
+			return nil
+		})
+	}
+
+	if workers < 1 {
+		workers = 1
 	}
+	if workers > len(jobs) {
+		workers = len(jobs)
+	}
+	var (
+		mu      sync.Mutex
+		lastErr error
+		wg      sync.WaitGroup
+	)
+	queue := make(chan func() error)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				if err := job(); err != nil {
+					mu.Lock()
+					lastErr = err
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+dispatch:
+	for _, job := range jobs {
+		// 空闲的 worker 和已取消的 ctx 同时就绪时 select 随机选择，先检查 ctx
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case queue <- job:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(queue)
+	wg.Wait()
 	return lastErr
 }
 
+// reportError 将错误交给 errChan。errChan 已满或为 nil 时只记录日志，处理器不会因此阻塞
+func reportError(errChan chan error, err error) {
+	select {
+	case errChan <- err:
+	default:
+		log.Error("处理器出错: %v", err)
+	}
+}
+
 // intervalProcessor 定时处理器结构体
 type intervalProcessor struct {
-	config   Config
-	stopChan chan bool
-	doneChan chan bool
-	errChan  chan error
-	interval int
+	config    Config
+	errChan   chan error
+	interval  int
+	resources *resourceCache
+	resync    chan struct{}
 }
 
 // IntervalProcessor 构造函数，返回一个新的定时处理器
 // 参数:
 //   - config: 配置信息
-//   - stopChan: 停止信号
-//   - doneChan: 完成信号
 //   - errChan: 错误信号
 //   - interval: 执行间隔时间（秒）
 // 返回值:
 //   - Processor: 返回一个 Processor 接口的实现
-func IntervalProcessor(config Config, stopChan, doneChan chan bool, errChan chan error, interval int) Processor {
-	return &intervalProcessor{config, stopChan, doneChan, errChan, interval}
+func IntervalProcessor(config Config, errChan chan error, interval int) Processor {
+	return &intervalProcessor{config, errChan, interval, newResourceCache(), make(chan struct{}, 1)}
 }
 
 // Process 定时处理模板资源的方法
-func (p *intervalProcessor) Process() {
-	defer close(p.doneChan)
+func (p *intervalProcessor) Process(ctx context.Context) error {
+	defer reloads.flush()
 	for {
-		ts, err := getTemplateResources(p.config)
+		// 出错的资源已被跳过，其余资源照常处理
+		ts, err := loadTemplateResources(p.config, p.resources)
 		if err != nil {
-			log.Fatal(err.Error())
-			break
+			reportError(p.errChan, fmt.Errorf("获取模板资源出错: %w", err))
+		}
+		if err := process(ctx, ts, p.config.Workers); err != nil {
+			reportError(p.errChan, err)
 		}
-		process(ts)
 		select {
-		case <-p.stopChan:
-			break
+		case <-ctx.Done():
+			return nil
+		case <-p.resync:
+			log.Info("立即重新处理所有模板资源")
 		case <-time.After(time.Duration(p.interval) * time.Second):
-			continue
 		}
 	}
 }
 
+// Resync 让定时处理器不再等待下一个周期，立即重新处理所有模板资源
+func (p *intervalProcessor) Resync() {
+	requestResync(p.resync)
+}
+
+// requestResync 向 resync 发送一次请求，已有请求在等待时不再重复发送
+func requestResync(resync chan struct{}) {
+	select {
+	case resync <- struct{}{}:
+	default:
+	}
+}
+
 // watchProcessor 监控处理器结构体
 type watchProcessor struct {
-	config   Config
-	stopChan chan bool
-	doneChan chan bool
-	errChan  chan error
-	wg       sync.WaitGroup
+	config    Config
+	errChan   chan error
+	wg        sync.WaitGroup
+	resources *resourceCache
+	monitors  map[*TemplateResource]*monitor
+	resync    chan struct{}
+	interval  int // 大于 0 时每隔 interval 秒重新处理所有模板资源
+}
+
+// monitor 记录一个正在监听后端变化的资源
+type monitor struct {
+	t      *TemplateResource
+	group  *resourceGroup
+	ctx    context.Context
+	cancel context.CancelFunc // 停止监听
+	wakeup chan struct{}      // 让正在等待的 WatchPrefix 提前返回
+	done   chan struct{}
+	prev   *monitor // 同一资源之前的监听，结束后才开始处理
 }
 
+// dirSettleDelay 是 conf.d 或模板目录最后一次变化之后、重新加载之前等待的时间，
+// 避免文件还在写入时就加载
+const dirSettleDelay = 500 * time.Millisecond
+
 // WatchProcessor 构造函数，返回一个新的监控处理器
 // 参数:
 //   - config: 配置信息
-//   - stopChan: 停止信号
-//   - doneChan: 完成信号
 //   - errChan: 错误信号
 // 返回值:
 //   - Processor: 返回一个 Processor 接口的实现
-func WatchProcessor(config Config, stopChan, doneChan chan bool, errChan chan error) Processor {
-	var wg sync.WaitGroup
-	return &watchProcessor{config, stopChan, doneChan, errChan, wg}
+func WatchProcessor(config Config, errChan chan error) Processor {
+	return &watchProcessor{
+		config:    config,
+		errChan:   errChan,
+		resources: newResourceCache(),
+		monitors:  make(map[*TemplateResource]*monitor),
+		resync:    make(chan struct{}, 1),
+	}
 }
 
-// Process 监控处理模板资源的方法
-func (p *watchProcessor) Process() {
-	defer close(p.doneChan)
-	ts, err := getTemplateResources(p.config)
-	if err != nil {
-		log.Fatal("获取模板资源出错: %v", err)
-		return
+// HybridProcessor 构造函数，返回一个同时监听和定时处理的处理器：后端变化时立即处理，
+// 另外每隔 interval 秒重新加载并处理所有模板资源，以补上遗漏的变更通知，
+// 并修复在 confd 之外被修改的目标文件
+// 参数:
+//   - config: 配置信息
+//   - errChan: 错误信号
+//   - interval: 执行间隔时间（秒）
+// 返回值:
+//   - Processor: 返回一个 Processor 接口的实现
+func HybridProcessor(config Config, errChan chan error, interval int) Processor {
+	p := WatchProcessor(config, errChan).(*watchProcessor)
+	p.interval = interval
+	return p
+}
+
+// Resync 让所有资源的监听立即重新处理一次资源，同时重新加载 conf.d 和模板目录
+func (p *watchProcessor) Resync() {
+	requestResync(p.resync)
+}
+
+// Process 监控处理模板资源的方法。conf.d 或模板目录变化时增加、删除或重启资源的监听，
+// 模板变化后重新渲染
+func (p *watchProcessor) Process(ctx context.Context) error {
+	defer reloads.flush()
+	defer p.wg.Wait()
+	if err := p.reconcile(ctx); err != nil {
+		reportError(p.errChan, fmt.Errorf("获取模板资源出错: %w", err))
+	}
+
+	watcher := newDirWatcher(p.config.ConfigDir, p.config.TemplateDir)
+	defer watcher.Close()
+	var tick <-chan time.Time
+	if p.interval > 0 {
+		ticker := time.NewTicker(time.Duration(p.interval) * time.Second)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	var settle <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			p.stopAll()
+			return nil
+		case <-p.resync:
+			log.Info("立即重新处理所有模板资源")
+			if err := p.reconcile(ctx); err != nil {
+				reportError(p.errChan, err)
+			}
+		case <-tick:
+			log.Info("定时重新处理所有模板资源")
+			if err := p.reconcile(ctx); err != nil {
+				reportError(p.errChan, err)
+			}
+		case <-watcher.Events():
+			settle = time.After(dirSettleDelay)
+		case <-settle:
+			settle = nil
+			log.Info("conf.d 或模板目录有变化，重新加载模板资源")
+			if err := p.reconcile(ctx); err != nil {
+				reportError(p.errChan, err)
+			}
+		}
+	}
+}
+
+// stopAll 停止所有资源的监听，正在进行的处理会先完成
+func (p *watchProcessor) stopAll() {
+	for t, m := range p.monitors {
+		m.cancel()
+		delete(p.monitors, t)
+	}
+}
+
+// reconcile 重新加载模板资源：停止已删除或已变化的资源的监听，为新的资源启动监听，
+// 其余资源重新处理一次，使模板的修改生效
+func (p *watchProcessor) reconcile(ctx context.Context) error {
+	ts, err := loadTemplateResources(p.config, p.resources)
+	want := make(map[*TemplateResource]bool, len(ts))
+	for _, t := range ts {
+		want[t] = true
+	}
+	stopped := make(map[*TemplateResource]*monitor)
+	for t, m := range p.monitors {
+		if !want[t] || t.group != m.group {
+			log.Info("停止监听 %s", t.resourcePath)
+			m.cancel()
+			delete(p.monitors, t)
+			stopped[t] = m
+		}
 	}
 	for _, t := range ts {
-		t := t
+		if m, ok := p.monitors[t]; ok {
+			m.wake()
+			continue
+		}
+		log.Info("开始监听 %s", t.resourcePath)
+		m := &monitor{
+			t:      t,
+			group:  t.group,
+			wakeup: make(chan struct{}, 1),
+			done:   make(chan struct{}),
+			prev:   stopped[t],
+		}
+		m.ctx, m.cancel = context.WithCancel(ctx)
+		p.monitors[t] = m
 		p.wg.Add(1)
-		go p.monitorPrefix(t)
+		go p.monitorPrefix(m)
+	}
+	return err
+}
+
+// wake 让监听立即处理一次资源
+func (m *monitor) wake() {
+	select {
+	case m.wakeup <- struct{}{}:
+	default:
 	}
-	p.wg.Wait()
+}
+
+// watch 等待后端中 keys 在 index 之后的变化。被 wake 唤醒时返回原来的 index 和 nil
+func (m *monitor) watch(keys []string, index uint64) (uint64, error) {
+	ctx, cancel := context.WithCancel(m.ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-m.wakeup:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	next, err := m.t.storeClient.WatchPrefix(ctx, m.t.Prefix, keys, index)
+	if err != nil && ctx.Err() != nil && m.ctx.Err() == nil {
+		return index, nil
+	}
+	return next, err
+}
+
+// keys 返回资源当前引用的键。资源组的成员可能正被其他成员的监听处理，需要持有组的锁
+func (m *monitor) keys() []string {
+	if m.group != nil {
+		m.group.mu.Lock()
+		defer m.group.mu.Unlock()
+	}
+	return util.AppendPrefix(m.t.Prefix, append(append([]string(nil), m.t.Keys...), m.t.optionalKeys...))
 }
 
 // monitorPrefix 监控某一模板资源的方法
 // 参数:
-//   - t: 模板资源
-func (p *watchProcessor) monitorPrefix(t *TemplateResource) {
+//   - m: 资源的监听
+func (p *watchProcessor) monitorPrefix(m *monitor) {
 	defer p.wg.Done()
-	keys := util.AppendPrefix(t.Prefix, t.Keys)
+	defer close(m.done)
+	if m.prev != nil {
+		<-m.prev.done
+		m.prev = nil
+	}
+	t := m.t
+	keys := m.keys()
+	t.lastIndex = 0
+	// 资源被删除、监听被替换或处理器停止时，释放在后端注册的监听
+	var registered []string
+	defer func() { unwatch(t.storeClient, registered) }()
 	for {
-		index, err := t.storeClient.WatchPrefix(t.Prefix, keys, t.lastIndex, p.stopChan)
+		register := t.lastIndex == 0
+		index, err := m.watch(keys, t.lastIndex)
+		if register && err == nil {
+			// 先注册新的键再释放原来的，两者共有的键不会中断监听
+			unwatch(t.storeClient, registered)
+			registered = keys
+		}
+		if m.ctx.Err() != nil {
+			return
+		}
 		if err != nil {
-			p.errChan <- err
-			time.Sleep(time.Second * 2)
+			reportError(p.errChan, err)
+			select {
+			case <-m.ctx.Done():
+				return
+			case <-time.After(time.Second * 2):
+			}
 			continue
 		}
 		t.lastIndex = index
-		if err := t.process(); err != nil {
-			p.errChan <- err
+		if m.group != nil {
+			err = m.group.process()
+		} else {
+			err = t.process()
+		}
+		if err != nil {
+			reportError(p.errChan, err)
+		}
+		// 模板变化后引用的键可能随之变化，需要重新注册监听
+		if current := m.keys(); !sameKeys(current, keys) {
+			keys = current
+			t.lastIndex = 0
 		}
 	}
 }
 
+// unwatch 释放 WatchPrefix 为 keys 注册的监听，后端不需要释放时什么也不做
+func unwatch(c backends.StoreClient, keys []string) {
+	if u, ok := c.(backends.Unwatcher); ok && len(keys) > 0 {
+		u.Unwatch(keys)
+	}
+}
+
+func sameKeys(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 // getTemplateResources 获取模板资源
 // 参数:
 //   - config: 配置信息
@@ -143,6 +436,17 @@ func (p *watchProcessor) monitorPrefix(t *TemplateResource) {
 //   - []*TemplateResource: 模板资源列表
 //   - error: 是否有错误发生
 func getTemplateResources(config Config) ([]*TemplateResource, error) {
+	return loadTemplateResources(config, nil)
+}
+
+// loadTemplateResources 获取模板资源，cache 不为空时复用未变化的资源
+// 参数:
+//   - config: 配置信息
+//   - cache: 资源缓存，可以为 nil
+// 返回值:
+//   - []*TemplateResource: 模板资源列表
+//   - error: 是否有错误发生
+func loadTemplateResources(config Config, cache *resourceCache) ([]*TemplateResource, error) {
 	var lastError error
 	templates := make([]*TemplateResource, 0)
 	log.Debug("从 %s 加载模板", config.ConfDir)
@@ -161,17 +465,40 @@ func getTemplateResources(config Config) ([]*TemplateResource, error) {
 		log.Warning("未找到任何模板")
 	}
 
+	if cache != nil {
+		cache.prune(paths)
+	}
+
+	var groups []*resourceGroup
 	for _, p := range paths {
 		log.Debug(fmt.Sprintf("找到模板: %s", p))
 
-		t, err := NewTemplateResource(p, config)
+		var t *TemplateResource
+		var g *resourceGroup
+		if cache != nil {
+			t, g, err = cache.load(p, config)
+		} else {
+			t, g, err = loadConfFile(p, config)
+		}
 
 		if err != nil {
 			lastError = fmt.Errorf("为 %s 创建模板资源出错: %w", p, err)
 			continue
 		}
+		if g != nil {
+			groups = append(groups, g)
+			continue
+		}
 		templates = append(templates, t)
 	}
 
+	templates, err = rejectDuplicateDests(templates)
+	if err != nil {
+		lastError = err
+	}
+	templates, err = linkGroups(templates, groups)
+	if err != nil {
+		lastError = err
+	}
 	return templates, lastError
 }
\ No newline at end of file
@@ -0,0 +1,329 @@
+package template
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitFor 等待 cond 成立，超过 5 秒则失败
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("等待 %s 超时", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// startProcessor 在后台运行 p，返回的 stop 取消 ctx 并返回 Process 的结果
+func startProcessor(t *testing.T, p Processor) (stop func() error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- p.Process(ctx) }()
+	stopped := false
+	stop = func() error {
+		t.Helper()
+		stopped = true
+		cancel()
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("Process 在 ctx 取消后没有返回")
+			return nil
+		}
+	}
+	t.Cleanup(func() {
+		if !stopped {
+			stop()
+		}
+	})
+	return stop
+}
+
+func pendingStageFiles() int {
+	stageFiles.mu.Lock()
+	defer stageFiles.mu.Unlock()
+	return len(stageFiles.names)
+}
+
+func newProcessorEnv(t *testing.T) *testEnv {
+	e := newTestEnv(t, map[string]string{"/a": "1"})
+	e.writeTemplate("t.tmpl", `{{getv "/a"}}`)
+	e.writeResource("t.toml", "[template]\nsrc = \"t.tmpl\"\ndest = \"{{out}}/t.conf\"\n")
+	return e
+}
+
+func TestIntervalProcessor(t *testing.T) {
+	e := newProcessorEnv(t)
+	p := IntervalProcessor(e.config, nil, 3600)
+	stop := startProcessor(t, p)
+	waitFor(t, "首次渲染", func() bool { return e.readOut("t.conf") == "1" })
+
+	// 不等待下一个周期，Resync 后立即处理
+	e.store.setQuiet("/a", "2")
+	p.Resync()
+	waitFor(t, "Resync 后重新渲染", func() bool { return e.readOut("t.conf") == "2" })
+
+	if err := stop(); err != nil {
+		t.Fatalf("Process = %v", err)
+	}
+}
+
+func TestWatchProcessor(t *testing.T) {
+	e := newProcessorEnv(t)
+	stop := startProcessor(t, WatchProcessor(e.config, nil))
+	waitFor(t, "首次渲染", func() bool { return e.readOut("t.conf") == "1" })
+
+	e.store.set("/a", "2")
+	waitFor(t, "后端变化后重新渲染", func() bool { return e.readOut("t.conf") == "2" })
+
+	// conf.d 中新增的资源会开始监听
+	e.store.set("/b", "x")
+	e.writeTemplate("u.tmpl", `{{getv "/b"}}`)
+	e.writeResource("u.toml", "[template]\nsrc = \"u.tmpl\"\ndest = \"{{out}}/u.conf\"\n")
+	waitFor(t, "新资源渲染", func() bool { return e.readOut("u.conf") == "x" })
+
+	if err := stop(); err != nil {
+		t.Fatalf("Process = %v", err)
+	}
+}
+
+// 模板目录中新增、删除或修改模板后重新渲染，不需要等待后端变化
+func TestWatchProcessorRerendersOnTemplateChange(t *testing.T) {
+	e := newProcessorEnv(t)
+	e.writeResource("u.toml", "[template]\nsrc = \"u.tmpl\"\ndest = \"{{out}}/u.conf\"\nkeys = [\"/a\"]\n")
+	errChan := make(chan error, 100)
+	stop := startProcessor(t, WatchProcessor(e.config, errChan))
+	waitFor(t, "首次渲染", func() bool { return e.readOut("t.conf") == "1" })
+
+	e.writeTemplate("t.tmpl", `a={{getv "/a"}}`)
+	waitFor(t, "修改模板后重新渲染", func() bool { return e.readOut("t.conf") == "a=1" })
+
+	e.writeTemplate("u.tmpl", `u={{getv "/a"}}`)
+	waitFor(t, "新增模板后渲染", func() bool { return e.readOut("u.conf") == "u=1" })
+
+	if err := os.Remove(filepath.Join(e.config.TemplateDir, "u.tmpl")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "删除模板后报告错误", func() bool {
+		for {
+			select {
+			case err := <-errChan:
+				if strings.Contains(err.Error(), "u.tmpl") {
+					return true
+				}
+			default:
+				return false
+			}
+		}
+	})
+	if got := e.readOut("u.conf"); got != "u=1" {
+		t.Errorf("u.conf = %q, want the last rendered content kept", got)
+	}
+	e.writeTemplate("u.tmpl", `u2={{getv "/a"}}`)
+	waitFor(t, "恢复模板后重新渲染", func() bool { return e.readOut("u.conf") == "u2=1" })
+
+	if err := stop(); err != nil {
+		t.Fatalf("Process = %v", err)
+	}
+}
+
+// 删除资源或资源不再引用某个键时，释放在后端注册的监听
+func TestWatchProcessorReleasesListeners(t *testing.T) {
+	e := newProcessorEnv(t)
+	e.store.set("/b", "x")
+	e.store.set("/c", "y")
+	e.writeTemplate("u.tmpl", `{{getv "/b"}}`)
+	e.writeResource("u.toml", "[template]\nsrc = \"u.tmpl\"\ndest = \"{{out}}/u.conf\"\n")
+	stop := startProcessor(t, WatchProcessor(e.config, nil))
+	waitFor(t, "首次渲染", func() bool { return e.readOut("t.conf") == "1" && e.readOut("u.conf") == "x" })
+	if a, b := e.store.watchCount("/a"), e.store.watchCount("/b"); a != 1 || b != 1 {
+		t.Fatalf("watch counts = /a:%d /b:%d, want 1 each", a, b)
+	}
+
+	e.writeTemplate("u.tmpl", `{{getv "/c"}}`)
+	waitFor(t, "模板引用新的键", func() bool { return e.readOut("u.conf") == "y" })
+	waitFor(t, "释放不再引用的键", func() bool { return e.store.watchCount("/b") == 0 && e.store.watchCount("/c") == 1 })
+
+	if err := os.Remove(filepath.Join(e.config.ConfigDir, "u.toml")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "释放已删除资源的监听", func() bool { return e.store.watchCount("/c") == 0 })
+	if got := e.store.watchCount("/a"); got != 1 {
+		t.Errorf("watch count of /a = %d, want the remaining resource to keep listening", got)
+	}
+
+	if err := stop(); err != nil {
+		t.Fatalf("Process = %v", err)
+	}
+	if got := e.store.watchCount("/a"); got != 0 {
+		t.Errorf("watch count of /a = %d after stop, want 0", got)
+	}
+}
+
+func TestHybridProcessor(t *testing.T) {
+	e := newProcessorEnv(t)
+	stop := startProcessor(t, HybridProcessor(e.config, nil, 1))
+	waitFor(t, "首次渲染", func() bool { return e.readOut("t.conf") == "1" })
+
+	e.store.set("/a", "2")
+	waitFor(t, "后端变化后重新渲染", func() bool { return e.readOut("t.conf") == "2" })
+
+	// 丢失的变更通知由定时处理补上
+	e.store.setQuiet("/a", "3")
+	waitFor(t, "定时处理", func() bool { return e.readOut("t.conf") == "3" })
+
+	if err := stop(); err != nil {
+		t.Fatalf("Process = %v", err)
+	}
+}
+
+// 加载出错的资源被跳过并通过 errChan 报告，其余资源照常处理，处理器不会退出
+func TestProcessorSkipsResourcesThatFailToLoad(t *testing.T) {
+	modes := map[string]func(Config, chan error) Processor{
+		"interval": func(c Config, errChan chan error) Processor { return IntervalProcessor(c, errChan, 3600) },
+		"watch":    func(c Config, errChan chan error) Processor { return WatchProcessor(c, errChan) },
+	}
+	for name, newProcessor := range modes {
+		t.Run(name, func(t *testing.T) {
+			e := newProcessorEnv(t)
+			e.store.set("/b", "x")
+			e.writeTemplate("u.tmpl", `{{getv "/b"}}`)
+			e.writeResource("u.toml", "[template]\nsrc = \"u.tmpl\"\ndest = \"{{out}}/u.conf\"\n")
+			// 与 t.toml 声明了相同的 dest
+			e.writeResource("v.toml", "[template]\nsrc = \"u.tmpl\"\ndest = \"{{out}}/t.conf\"\n")
+
+			errChan := make(chan error, 10)
+			stop := startProcessor(t, newProcessor(e.config, errChan))
+			select {
+			case err := <-errChan:
+				if !strings.Contains(err.Error(), "相同的 dest") {
+					t.Errorf("error = %v, want the duplicate dest", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("load error was not reported")
+			}
+			waitFor(t, "有效的资源渲染", func() bool { return e.readOut("t.conf") == "1" && e.readOut("u.conf") == "x" })
+
+			if err := stop(); err != nil {
+				t.Fatalf("Process = %v", err)
+			}
+		})
+	}
+}
+
+// ctx 取消后正在进行的同步会完成，不留下暂存文件
+func TestProcessorStopWaitsForInFlightSync(t *testing.T) {
+	for _, mode := range []string{"interval", "watch", "hybrid"} {
+		t.Run(mode, func(t *testing.T) {
+			e := newTestEnv(t, map[string]string{"/a": "1"})
+			e.writeTemplate("t.tmpl", `{{getv "/a"}}`)
+			e.writeResource("t.toml", "[template]\nsrc = \"t.tmpl\"\ndest = \"{{out}}/t.conf\"\ncheck_cmd = \"sleep 0.3\"\n")
+			var p Processor
+			switch mode {
+			case "interval":
+				p = IntervalProcessor(e.config, nil, 3600)
+			case "watch":
+				p = WatchProcessor(e.config, nil)
+			case "hybrid":
+				p = HybridProcessor(e.config, nil, 3600)
+			}
+			stop := startProcessor(t, p)
+			waitFor(t, "开始检查暂存文件", func() bool { return pendingStageFiles() > 0 })
+
+			if err := stop(); err != nil {
+				t.Fatalf("Process = %v", err)
+			}
+			if got := e.readOut("t.conf"); got != "1" {
+				t.Errorf("t.conf = %q, want the in-flight sync to finish", got)
+			}
+			if n := pendingStageFiles(); n != 0 {
+				t.Errorf("%d stage files left after Process returned", n)
+			}
+			if files := e.outFiles(); len(files) != 1 {
+				t.Errorf("out = %v, want only t.conf", files)
+			}
+		})
+	}
+}
+
+func TestProcessStopsDispatchOnCancel(t *testing.T) {
+	e := newTestEnv(t, map[string]string{"/a": "1"})
+	e.writeTemplate("t.tmpl", `{{getv "/a"}}`)
+	var ts []*TemplateResource
+	for _, name := range []string{"a", "b", "c"} {
+		e.writeResource(name+".toml", "[template]\nsrc = \"t.tmpl\"\ndest = \"{{out}}/"+name+".conf\"\n")
+		ts = append(ts, e.resource(name+".toml"))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < 20; i++ {
+		if err := process(ctx, ts, 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if files := e.outFiles(); len(files) != 0 {
+		t.Errorf("out = %v, want nothing processed after cancel", files)
+	}
+}
+
+func TestRemoveStageFiles(t *testing.T) {
+	e := newProcessorEnv(t)
+	r := e.resource("t.toml")
+	if err := r.setVars(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.createStageFile(); err != nil {
+		t.Fatal(err)
+	}
+	staged := r.StageFile.Name()
+	r.StageFile.Close()
+
+	if n := RemoveStageFiles(); n != 1 {
+		t.Errorf("RemoveStageFiles() = %d, want 1", n)
+	}
+	if _, err := os.Stat(staged); !os.IsNotExist(err) {
+		t.Errorf("stage file %s still exists: %v", staged, err)
+	}
+	if n := RemoveStageFiles(); n != 0 {
+		t.Errorf("second RemoveStageFiles() = %d, want 0", n)
+	}
+
+	// 正常的处理结束后不会留下需要清理的暂存文件
+	if err := e.resource("t.toml").process(); err != nil {
+		t.Fatal(err)
+	}
+	if n := RemoveStageFiles(); n != 0 {
+		t.Errorf("RemoveStageFiles() after process = %d, want 0", n)
+	}
+	if _, err := os.Stat(filepath.Join(e.out, "t.conf")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReportErrorDoesNotBlock(t *testing.T) {
+	errChan := make(chan error, 1)
+	done := make(chan struct{})
+	go func() {
+		reportError(errChan, errors.New("first"))
+		reportError(errChan, errors.New("dropped"))
+		reportError(nil, errors.New("nil channel"))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("reportError blocked")
+	}
+	if err := <-errChan; err.Error() != "first" {
+		t.Errorf("errChan = %v, want first", err)
+	}
+}
@@ -0,0 +1,218 @@
+package template
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Risingtao/nacos-confd/log"
+)
+
+// reloads 合并所有资源和资源组的 reload 命令
+var reloads = newReloadScheduler()
+
+// reloadScheduler 按命令字符串合并 reload 请求：debounce 窗口内相同命令的请求只执行一次，
+// 同一命令两次执行之间至少间隔 minInterval
+type reloadScheduler struct {
+	mu      sync.Mutex
+	pending map[string]*pendingReload
+	lastRun map[string]time.Time
+	wg      sync.WaitGroup
+}
+
+type pendingReload struct {
+	key      string
+	cmd      *command
+	due      time.Time
+	timer    *time.Timer
+	requests int
+	onResult []func(*CommandResult, error)
+}
+
+func newReloadScheduler() *reloadScheduler {
+	return &reloadScheduler{
+		pending: make(map[string]*pendingReload),
+		lastRun: make(map[string]time.Time),
+	}
+}
+
+// schedule 安排执行 cmd。命令在最后一次请求之后 debounce 时执行，且距上一次执行不少于 minInterval；
+// 执行前到达的相同命令（按命令文本判断）的请求都合并到这一次，执行时使用最后一次请求的环境变量。
+// onResult 不为 nil 时在执行后以结果调用
+func (s *reloadScheduler) schedule(cmd *command, debounce, minInterval time.Duration, onResult func(*CommandResult, error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := cmd.String()
+	p, ok := s.pending[key]
+	if !ok {
+		p = &pendingReload{key: key}
+		s.pending[key] = p
+		s.wg.Add(1)
+	}
+	p.cmd = cmd
+	p.requests++
+	if onResult != nil {
+		p.onResult = append(p.onResult, onResult)
+	}
+
+	due := time.Now().Add(debounce)
+	if next := s.lastRun[key].Add(minInterval); due.Before(next) {
+		due = next
+	}
+	if due.After(p.due) {
+		p.due = due
+	}
+	if p.timer == nil {
+		p.timer = time.AfterFunc(time.Until(p.due), func() { s.fire(p) })
+	} else {
+		log.Debug("合并 reload 请求: %s", key)
+	}
+}
+
+// fire 在定时器到期时执行命令，期间有新请求推迟了执行时间则重新计时
+func (s *reloadScheduler) fire(p *pendingReload) {
+	s.mu.Lock()
+	if s.pending[p.key] != p {
+		// 已被 flush 执行
+		s.mu.Unlock()
+		return
+	}
+	if wait := time.Until(p.due); wait > 0 {
+		p.timer.Reset(wait)
+		s.mu.Unlock()
+		return
+	}
+	delete(s.pending, p.key)
+	s.lastRun[p.key] = time.Now()
+	s.mu.Unlock()
+
+	s.run(p)
+}
+
+// flush 立即执行所有等待中的命令，并等待正在执行的命令结束
+func (s *reloadScheduler) flush() {
+	s.mu.Lock()
+	ps := make([]*pendingReload, 0, len(s.pending))
+	for key, p := range s.pending {
+		delete(s.pending, key)
+		s.lastRun[key] = time.Now()
+		ps = append(ps, p)
+	}
+	s.mu.Unlock()
+
+	for _, p := range ps {
+		s.run(p)
+	}
+	s.wg.Wait()
+}
+
+func (s *reloadScheduler) run(p *pendingReload) {
+	defer s.wg.Done()
+	if p.requests > 1 {
+		log.Info("执行reload脚本: %s (合并了 %d 个请求)", p.key, p.requests)
+	} else {
+		log.Info("执行reload脚本: %s", p.key)
+	}
+	res, err := p.cmd.run()
+	if err != nil {
+		log.Error("Reload脚本执行失败: %v", err)
+	} else {
+		log.Info("Reload脚本执行成功")
+	}
+	for _, fn := range p.onResult {
+		fn(res, err)
+	}
+}
+
+// parseDurationOption 解析 reload_debounce 这类时长选项，空字符串表示 0
+func parseDurationOption(name, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("无效的 %s %q: %v", name, value, err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("%s 不能为负数: %s", name, value)
+	}
+	return d, nil
+}
+
+// reloadTiming 解析 reload_debounce 和 reload_min_interval，为空时使用全局配置
+func reloadTiming(config Config, debounce, minInterval string) (time.Duration, time.Duration, error) {
+	if debounce == "" {
+		debounce = config.ReloadDebounce
+	}
+	if minInterval == "" {
+		minInterval = config.ReloadMinInterval
+	}
+	d, err := parseDurationOption("reload_debounce", debounce)
+	if err != nil {
+		return 0, 0, err
+	}
+	m, err := parseDurationOption("reload_min_interval", minInterval)
+	if err != nil {
+		return 0, 0, err
+	}
+	return d, m, nil
+}
+
+// debounced 报告资源的 reload_cmd 是否交给 reloads 合并执行
+func (t *TemplateResource) debounced() bool {
+	return t.reloadDebounce > 0 || t.reloadMinInterval > 0
+}
+
+// scheduleReload 将 reload_cmd 交给 reloads 合并执行。reload 或之后的健康检查失败时，
+// 若设置了 backup，将 dest 恢复为 backup 并再次安排 reload。
+// 回调在定时器的 goroutine 中执行，而 foreach 资源会在渲染各文件时修改 t.Dest，
+// 因此命令及其环境变量都在安排时生成，回调中不再读取 t.Dest
+func (t *TemplateResource) scheduleReload(backup string) {
+	dest := t.Dest
+	rollback := t.Backup > 0
+	reloadCmd := t.reloadCommand()
+	var healthCmd *command
+	if t.HealthCheckCmd != "" {
+		healthCmd = t.healthCheckCommand()
+	}
+	reloads.schedule(reloadCmd, t.reloadDebounce, t.reloadMinInterval, func(res *CommandResult, err error) {
+		t.recordResult("reload", res)
+		if err != nil {
+			err = fmt.Errorf("重新加载配置失败: %v", err)
+		} else if healthCmd != nil {
+			log.Debug("执行健康检查: %s", healthCmd)
+			if err = t.runRecorded(healthCmd); err != nil {
+				err = fmt.Errorf("健康检查失败: %v", err)
+			}
+		}
+		if err == nil {
+			return
+		}
+		if !rollback {
+			log.Error("目标配置 %s 更新后失败: %v", dest, err)
+			return
+		}
+		defer destLocks.lock(dest)()
+		t.rollbackDest(dest, backup, err, func() error {
+			t.scheduleCommand(reloadCmd)
+			return nil
+		})
+	})
+}
+
+// requestReload 执行 reload_cmd，需要合并时只安排执行而不等待结果
+func (t *TemplateResource) requestReload() error {
+	if t.debounced() {
+		t.scheduleCommand(t.reloadCommand())
+		return nil
+	}
+	return t.reload()
+}
+
+// scheduleCommand 将已生成的 reload 命令交给 reloads 合并执行，只记录结果
+func (t *TemplateResource) scheduleCommand(c *command) {
+	reloads.schedule(c, t.reloadDebounce, t.reloadMinInterval, func(res *CommandResult, err error) {
+		t.recordResult("reload", res)
+	})
+}
@@ -0,0 +1,33 @@
+package template
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// foreach 资源在 reload 执行前就开始渲染下一个文件，reload 和健康检查仍使用安排时的 dest
+func TestScheduleReloadCapturesDest(t *testing.T) {
+	e := newTestEnv(t, map[string]string{"/a": "1"})
+	e.writeTemplate("t.tmpl", `{{getv "/a"}}`)
+	logPath := filepath.Join(e.dir, "reload.log")
+	e.writeResource("t.toml", "[template]\nsrc = \"t.tmpl\"\ndest = \"{{out}}/t.conf\"\n"+
+		"reload_cmd = \"echo reload $CONFD_DEST >> "+logPath+"\"\n"+
+		"health_check_cmd = \"echo health $CONFD_DEST >> "+logPath+"\"\n"+
+		"reload_debounce = \"1h\"\n")
+	r := e.resource("t.toml")
+	want := r.Dest
+	r.scheduleReload("")
+	r.Dest = filepath.Join(e.out, "other.conf")
+	reloads.flush()
+
+	data, err := ioutil.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(got) != 2 || got[0] != "reload "+want || got[1] != "health "+want {
+		t.Errorf("reload log = %q, want reload and health check of %s", got, want)
+	}
+}
@@ -0,0 +1,102 @@
+package template
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/Risingtao/nacos-confd/log"
+)
+
+// setupReloadTarget 校验 reload_signal 和 reload_systemd_unit，并把 ReloadCmd 设置为对应的描述，
+// 这样 reload 的合并、日志和通知与 reload_cmd 一致
+func (t *TemplateResource) setupReloadTarget() error {
+	targets := 0
+	for _, set := range []bool{t.ReloadCmd != "", t.ReloadSignal != "", t.ReloadSystemdUnit != ""} {
+		if set {
+			targets++
+		}
+	}
+	if targets > 1 {
+		return errors.New("reload_cmd、reload_argv、reload_signal 和 reload_systemd_unit 只能设置一个")
+	}
+	// 信号和 D-Bus 调用都在 confd 进程内完成，无法切换到其他用户
+	if t.RunAs != "" && (t.ReloadSignal != "" || t.ReloadSystemdUnit != "") {
+		return errors.New("run_as 不能与 reload_signal 或 reload_systemd_unit 一起使用")
+	}
+
+	if t.ReloadSignal == "" {
+		if t.Pidfile != "" || t.ProcessName != "" {
+			return errors.New("pidfile 和 process_name 只能与 reload_signal 一起使用")
+		}
+	} else {
+		if (t.Pidfile == "") == (t.ProcessName == "") {
+			return errors.New("reload_signal 需要设置 pidfile 或 process_name 中的一个")
+		}
+		if _, err := parseSignal(t.ReloadSignal); err != nil {
+			return err
+		}
+		if t.Pidfile != "" {
+			t.ReloadCmd = fmt.Sprintf("reload_signal %s pidfile=%s", t.ReloadSignal, t.Pidfile)
+		} else {
+			t.ReloadCmd = fmt.Sprintf("reload_signal %s process_name=%s", t.ReloadSignal, t.ProcessName)
+		}
+	}
+
+	if t.ReloadSystemdUnit != "" {
+		t.ReloadCmd = "systemctl reload " + t.ReloadSystemdUnit
+	}
+	return nil
+}
+
+// nativeReload 返回 reload_signal 或 reload_systemd_unit 对应的 reload 操作，未设置时返回 nil
+func (t *TemplateResource) nativeReload() func() (string, error) {
+	switch {
+	case t.ReloadSignal != "":
+		return func() (string, error) {
+			return signalReload(t.ReloadSignal, t.Pidfile, t.ProcessName)
+		}
+	case t.ReloadSystemdUnit != "":
+		return func() (string, error) {
+			return systemdReload(t.ReloadSystemdUnit, t.reloadTimeout)
+		}
+	}
+	return nil
+}
+
+// systemdReload 重新加载 unit，unit 不存在时返回明确的错误。优先通过 D-Bus 调用 systemd，
+// 无法连接系统总线时改用 systemctl
+func systemdReload(unit string, timeout time.Duration) (string, error) {
+	out, err := systemdBusReload(unit, timeout)
+	if !errors.Is(err, errBusUnavailable) {
+		return out, err
+	}
+	log.Debug("%v，改用 systemctl 重新加载 %s", err, unit)
+	return systemctlReload(unit, timeout)
+}
+
+// systemctlReload 通过 systemctl 重新加载 unit
+func systemctlReload(unit string, timeout time.Duration) (string, error) {
+	if _, err := exec.LookPath("systemctl"); err != nil {
+		return "", fmt.Errorf("无法重新加载 systemd unit %s: 未找到 systemctl", unit)
+	}
+
+	show := &command{name: "reload", argv: []string{"systemctl", "show", "-p", "LoadState", unit}, timeout: timeout}
+	res, err := show.run()
+	if err != nil {
+		return "", fmt.Errorf("查询 systemd unit %s 失败: %v", unit, err)
+	}
+	state := strings.TrimPrefix(strings.TrimSpace(res.Output), "LoadState=")
+	if state == "not-found" {
+		return res.Output, fmt.Errorf("systemd unit %s 不存在", unit)
+	}
+
+	reload := &command{name: "reload", argv: []string{"systemctl", "reload", unit}, timeout: timeout}
+	res, err = reload.run()
+	if res == nil {
+		return "", err
+	}
+	return res.Output, err
+}
@@ -0,0 +1,115 @@
+//go:build !windows
+// +build !windows
+
+package template
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+var signalNames = map[string]syscall.Signal{
+	"HUP":   syscall.SIGHUP,
+	"INT":   syscall.SIGINT,
+	"QUIT":  syscall.SIGQUIT,
+	"TERM":  syscall.SIGTERM,
+	"USR1":  syscall.SIGUSR1,
+	"USR2":  syscall.SIGUSR2,
+	"WINCH": syscall.SIGWINCH,
+	"ALRM":  syscall.SIGALRM,
+}
+
+// parseSignal 解析 reload_signal，支持 HUP、SIGHUP 这样的名称和信号编号
+func parseSignal(name string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(name); err == nil && n > 0 {
+		return syscall.Signal(n), nil
+	}
+	if sig, ok := signalNames[strings.TrimPrefix(strings.ToUpper(name), "SIG")]; ok {
+		return sig, nil
+	}
+	return 0, fmt.Errorf("不支持的 reload_signal %q", name)
+}
+
+// signalReload 向 pidfile 中记录的进程或名为 processName 的所有进程发送信号
+func signalReload(signal, pidfile, processName string) (string, error) {
+	sig, err := parseSignal(signal)
+	if err != nil {
+		return "", err
+	}
+
+	var pids []int
+	if pidfile != "" {
+		pid, err := readPidfile(pidfile)
+		if err != nil {
+			return "", err
+		}
+		pids = []int{pid}
+	} else {
+		if pids, err = findProcesses(processName); err != nil {
+			return "", err
+		}
+	}
+
+	var out []string
+	for _, pid := range pids {
+		if err := syscall.Kill(pid, sig); err != nil {
+			if err == syscall.ESRCH && pidfile != "" {
+				return strings.Join(out, "\n"), fmt.Errorf("pidfile %s 中的进程 %d 不存在，pid 文件可能已过期", pidfile, pid)
+			}
+			return strings.Join(out, "\n"), fmt.Errorf("向进程 %d 发送 %s 失败: %v", pid, signal, err)
+		}
+		out = append(out, fmt.Sprintf("已向进程 %d 发送 %s", pid, signal))
+	}
+	return strings.Join(out, "\n"), nil
+}
+
+func readPidfile(pidfile string) (int, error) {
+	data, err := ioutil.ReadFile(pidfile)
+	if err != nil {
+		return 0, fmt.Errorf("读取 pidfile %s 失败: %v", pidfile, err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("pidfile %s 的内容不是有效的 pid: %q", pidfile, strings.TrimSpace(string(data)))
+	}
+	if err := syscall.Kill(pid, 0); err == syscall.ESRCH {
+		return 0, fmt.Errorf("pidfile %s 中的进程 %d 不存在，pid 文件可能已过期", pidfile, pid)
+	}
+	return pid, nil
+}
+
+// findProcesses 通过 /proc 查找进程名（comm 或命令行第一个参数的文件名）为 name 的进程
+func findProcesses(name string) ([]int, error) {
+	dirs, err := filepath.Glob("/proc/[0-9]*")
+	if err != nil || len(dirs) == 0 {
+		return nil, fmt.Errorf("无法按 process_name 查找进程: /proc 不可用")
+	}
+	self := syscall.Getpid()
+	var pids []int
+	for _, dir := range dirs {
+		pid, err := strconv.Atoi(filepath.Base(dir))
+		if err != nil || pid == self {
+			continue
+		}
+		if comm, err := ioutil.ReadFile(filepath.Join(dir, "comm")); err == nil && strings.TrimSpace(string(comm)) == name {
+			pids = append(pids, pid)
+			continue
+		}
+		cmdline, err := ioutil.ReadFile(filepath.Join(dir, "cmdline"))
+		if err != nil || len(cmdline) == 0 {
+			continue
+		}
+		if argv0 := cmdline[:bytes.IndexByte(append(cmdline, 0), 0)]; filepath.Base(string(argv0)) == name {
+			pids = append(pids, pid)
+		}
+	}
+	if len(pids) == 0 {
+		return nil, fmt.Errorf("没有找到名为 %s 的进程", name)
+	}
+	return pids, nil
+}
@@ -0,0 +1,16 @@
+//go:build windows
+// +build windows
+
+package template
+
+import (
+	"errors"
+)
+
+func parseSignal(name string) (int, error) {
+	return 0, errors.New("Windows 不支持 reload_signal")
+}
+
+func signalReload(signal, pidfile, processName string) (string, error) {
+	return "", errors.New("Windows 不支持 reload_signal")
+}
@@ -0,0 +1,153 @@
+package template
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"text/template"
+	"text/template/parse"
+)
+
+// volatileFuncs 是结果不只取决于后端键值的模板函数，使用了它们的模板每轮都会重新渲染
+var volatileFuncs = map[string]bool{
+	"datetime":   true,
+	"now":        true,
+	"uuidv4":     true,
+	"getenv":     true,
+	"fileExists": true,
+	"lookupIP":   true,
+	"lookupIPV4": true,
+	"lookupIPV6": true,
+	"lookupSRV":  true,
+}
+
+// renderState 记录目标文件上一次成功同步时的输入摘要和文件状态
+type renderState struct {
+	digest string
+	stamp  []fileStamp
+	mode   os.FileMode
+	uid    int
+	gid    int
+}
+
+// isVolatileTemplate 报告模板是否使用了 volatileFuncs 中的函数或 .Render.Timestamp
+func isVolatileTemplate(tmpl *template.Template) bool {
+	volatile := false
+	walkTemplates(tmpl, func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.IdentifierNode:
+			volatile = volatile || volatileFuncs[n.Ident]
+		case *parse.FieldNode:
+			volatile = volatile || usesTimestamp(n.Ident)
+		case *parse.VariableNode:
+			volatile = volatile || usesTimestamp(n.Ident[1:])
+		}
+	})
+	return volatile
+}
+
+// usesTimestamp 报告字段引用是否可能取到 .Render.Timestamp，
+// 单独引用 .Render 时无法确定用途，按会用到处理
+func usesTimestamp(ident []string) bool {
+	return len(ident) > 0 && ident[0] == "Render" && (len(ident) == 1 || ident[1] == "Timestamp")
+}
+
+// renderDigest 计算决定 t.Dest 渲染结果的全部输入的摘要：获取到的键值、
+// 模板文件、展开项、文件属性和主机信息
+func (t *TemplateResource) renderDigest() string {
+	h := sha256.New()
+	write := func(parts ...string) {
+		for _, p := range parts {
+			fmt.Fprintf(h, "%d:%s;", len(p), p)
+		}
+	}
+
+	keys := make([]string, 0, len(t.fetched))
+	for k := range t.fetched {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		write(k, t.fetched[k])
+	}
+
+	for _, s := range t.parsedStamp {
+		write(s.path, strconv.FormatInt(s.modTime.UnixNano(), 10), strconv.FormatInt(s.size, 10))
+	}
+	write(t.Dest, t.FileMode.String(), strconv.Itoa(t.Uid), strconv.Itoa(t.Gid))
+	if t.item != nil {
+		write(t.item.Key)
+	}
+
+	host, _ := os.Hostname()
+	write(host)
+	write(hostIPs()...)
+	labels := make([]string, 0, len(t.hostLabels))
+	for k := range t.hostLabels {
+		labels = append(labels, k)
+	}
+	sort.Strings(labels)
+	for _, k := range labels {
+		write(k, t.hostLabels[k])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// unchangedSinceRender 报告 t.Dest 的输入摘要与上一次成功同步时相同，
+// 且目标文件此后没有被外部修改，包括内容、权限和属主属组
+func (t *TemplateResource) unchangedSinceRender(digest string) bool {
+	if t.volatile {
+		return false
+	}
+	last, ok := t.lastRender[t.Dest]
+	if !ok || last.digest != digest {
+		return false
+	}
+	cur, err := destState(t.Dest)
+	return err == nil && cur.mode == last.mode && cur.uid == last.uid && cur.gid == last.gid &&
+		sameStamps(last.stamp, cur.stamp)
+}
+
+// recordRender 在 t.Dest 同步成功后记录输入摘要和目标文件的当前状态
+func (t *TemplateResource) recordRender(digest string) {
+	if t.noop {
+		return
+	}
+	state, err := destState(t.Dest)
+	if err != nil {
+		delete(t.lastRender, t.Dest)
+		return
+	}
+	if t.lastRender == nil {
+		t.lastRender = make(map[string]renderState)
+	}
+	state.digest = digest
+	t.lastRender[t.Dest] = state
+}
+
+// forgetRenders 丢弃不在 current 中的目标文件的记录
+func (t *TemplateResource) forgetRenders(current map[string]bool) {
+	for dest := range t.lastRender {
+		if !current[dest] {
+			delete(t.lastRender, dest)
+		}
+	}
+}
+
+// destState 返回目标文件当前的状态，不含输入摘要
+func destState(dest string) (renderState, error) {
+	fi, err := os.Stat(dest)
+	if err != nil {
+		return renderState{}, err
+	}
+	uid, gid := fileOwner(fi)
+	return renderState{
+		stamp: []fileStamp{{path: dest, modTime: fi.ModTime(), size: fi.Size()}},
+		mode:  fi.Mode(),
+		uid:   uid,
+		gid:   gid,
+	}, nil
+}
@@ -0,0 +1,50 @@
+package template
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRenderRepairsOutOfBandChanges(t *testing.T) {
+	tests := []struct {
+		name   string
+		root   bool
+		tamper func(dest string) error
+	}{
+		{name: "chmod", tamper: func(dest string) error { return os.Chmod(dest, 0600) }},
+		{name: "chown", root: true, tamper: func(dest string) error { return os.Chown(dest, 1234, 1234) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.root && os.Geteuid() != 0 {
+				t.Skip("需要 root 权限")
+			}
+			e := newTestEnv(t, map[string]string{"/a": "1"})
+			e.writeTemplate("t.tmpl", `{{getv "/a"}}`)
+			e.writeResource("t.toml", "[template]\nsrc = \"t.tmpl\"\ndest = \"{{out}}/t.conf\"\nmode = \"0644\"\n")
+			r := e.resource("t.toml")
+			if err := r.process(); err != nil {
+				t.Fatal(err)
+			}
+			dest := filepath.Join(e.out, "t.conf")
+			want, err := destState(dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.tamper(dest); err != nil {
+				t.Fatal(err)
+			}
+			if err := r.process(); err != nil {
+				t.Fatal(err)
+			}
+			got, err := destState(dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.mode != want.mode || got.uid != want.uid || got.gid != want.gid {
+				t.Errorf("dest mode %v owner %d:%d, want %v %d:%d", got.mode, got.uid, got.gid, want.mode, want.uid, want.gid)
+			}
+		})
+	}
+}
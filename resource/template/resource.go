@@ -9,10 +9,8 @@ import (
 	"net"
 	"net/http"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
-	"runtime"
 	"strconv"
 	"strings"
 	"text/template"
@@ -27,41 +25,115 @@ import (
 )
 
 type Config struct {
-	ConfDir       string `toml:"confdir"`
-	ConfigDir     string
-	KeepStageFile bool
-	Noop          bool   `toml:"noop"`
-	Prefix        string `toml:"prefix"`
-	StoreClient   backends.StoreClient
-	SyncOnly      bool `toml:"sync-only"`
-	TemplateDir   string
-	PGPPrivateKey []byte
+	ConfDir           string `toml:"confdir"`
+	ConfigDir         string
+	KeepStageFile     bool
+	Noop              bool   `toml:"noop"`
+	Prefix            string `toml:"prefix"`
+	StoreClient       backends.StoreClient
+	SyncOnly          bool `toml:"sync-only"`
+	TemplateDir       string
+	PGPPrivateKey     []byte
+	Strict            bool              `toml:"strict"`
+	Labels            map[string]string `toml:"labels"`
+	BackupDir         string            `toml:"backup_dir"`
+	StateDir          string            `toml:"state_dir"`
+	ReloadDebounce    string            `toml:"reload_debounce"`
+	ReloadMinInterval string            `toml:"reload_min_interval"`
+	Workers           int               `toml:"workers"`
+	Version           string
+	GitSHA            string
 }
 
 type TemplateResourceConfig struct {
-	TemplateResource TemplateResource `toml:"template"`
+	TemplateResource *TemplateResource `toml:"template"`
 }
 
 type TemplateResource struct {
-	CheckCmd      string `toml:"check_cmd"`
-	Dest          string
-	FileMode      os.FileMode
-	Gid           int
-	Keys          []string
-	Mode          string
-	Prefix        string
-	ReloadCmd     string `toml:"reload_cmd"`
-	Src           string
-	StageFile     *os.File
-	Uid           int
-	funcMap       map[string]interface{}
-	lastIndex     uint64
-	keepStageFile bool
-	noop          bool
-	store         memkv.Store
-	storeClient   backends.StoreClient
-	syncOnly      bool
-	PGPPrivateKey []byte
+	Backup            int      `toml:"backup"`
+	BackupDir         string   `toml:"backup_dir"`
+	CheckArgv         []string `toml:"check_argv"`
+	CheckCmd          string   `toml:"check_cmd"`
+	CheckTimeout      string   `toml:"check_timeout"`
+	CreateDirs        bool     `toml:"create_dirs"`
+	Dest              string
+	DirMode           string `toml:"dir_mode"`
+	FileGroup         string `toml:"file_group"`
+	FileMode          os.FileMode
+	Foreach           string `toml:"foreach"`
+	ForeachService    string `toml:"foreach_service"`
+	Gid               int
+	Group             string   `toml:"group"`
+	HealthCheckCmd    string   `toml:"health_check_cmd"`
+	HookTimeout       string   `toml:"hook_timeout"`
+	Include           []string `toml:"include"`
+	Keys              []string
+	LeftDelimiter     string `toml:"left_delimiter"`
+	Mode              string
+	OnErrorCmd        string `toml:"on_error"`
+	OnMissing         string `toml:"on_missing"`
+	OnUnchangedCmd    string `toml:"on_unchanged"`
+	Owner             string `toml:"owner"`
+	Pidfile           string `toml:"pidfile"`
+	PostSyncCmd       string `toml:"post_sync"`
+	PreSyncCmd        string `toml:"pre_sync"`
+	Prefix            string
+	ProcessName       string   `toml:"process_name"`
+	ReloadArgv        []string `toml:"reload_argv"`
+	ReloadCmd         string   `toml:"reload_cmd"`
+	ReloadDebounce    string   `toml:"reload_debounce"`
+	ReloadMinInterval string   `toml:"reload_min_interval"`
+	ReloadSignal      string   `toml:"reload_signal"`
+	ReloadSystemdUnit string   `toml:"reload_systemd_unit"`
+	ReloadTimeout     string   `toml:"reload_timeout"`
+	RightDelimiter    string   `toml:"right_delimiter"`
+	RunAs             string   `toml:"run_as"`
+	Src               string
+	StageFile         *os.File
+	Strict            bool `toml:"strict"`
+	Uid               int
+	Validate          string `toml:"validate"`
+	funcMap           map[string]interface{}
+	lastIndex         uint64
+	keepStageFile     bool
+	noop              bool
+	store             memkv.Store
+	storeClient       backends.StoreClient
+	syncOnly          bool
+	templateDir       string
+	destTemplate      string
+	renderedDests     map[string]bool
+	statePath         string // 模板化 dest 的资源记录已生成文件的状态文件
+	item              *ForeachItem
+	declaredKeys      []string
+	optionalKeys      []string // 模板中只通过 exists 或带默认值的 getv 引用的键
+	parsed            *template.Template
+	parsedStamp       []fileStamp
+	destTmpl          *template.Template
+	values            map[string]interface{}
+	fetched           map[string]string
+	missing           []string
+	renderEmpty       bool
+	secrets           map[string]bool
+	volatile          bool
+	lastRender        map[string]renderState
+	cycleChanged      bool
+	cycleStarted      time.Time
+	group             *resourceGroup
+	dirMode           os.FileMode
+	txn               *groupTxn
+	reloadDebounce    time.Duration
+	reloadMinInterval time.Duration
+	checkTimeout      time.Duration
+	reloadTimeout     time.Duration
+	hookTimeout       time.Duration
+	runAs             *credential
+	status            *resourceStatus
+	resourcePath      string
+	hostLabels        map[string]string
+	version           string
+	gitSHA            string
+	PGPPrivateKey     []byte
 }
 
 // 错误类型
@@ -149,10 +221,10 @@ func NewTemplateResource(path string, config Config) (*TemplateResource, error)
 		return nil, errors.New("需要一个有效的 StoreClient。")
 	}
 
-	tc := &TemplateResourceConfig{TemplateResource{Uid: -1, Gid: -1}}
+	tc := &TemplateResourceConfig{&TemplateResource{Uid: -1, Gid: -1}}
 
 	log.Debug("从 " + path + " 加载模板资源")
-	_, err := toml.DecodeFile(path, &tc)
+	_, err := toml.DecodeFile(path, tc)
 	if err != nil {
 		return nil, fmt.Errorf("无法处理模板资源 %s - %s", path, err.Error())
 	}
@@ -164,6 +236,10 @@ func NewTemplateResource(path string, config Config) (*TemplateResource, error)
 	tr.funcMap = newFuncMap()
 	tr.store = memkv.New()
 	tr.syncOnly = config.SyncOnly
+	tr.templateDir = config.TemplateDir
+	tr.hostLabels = config.Labels
+	tr.version = config.Version
+	tr.gitSHA = config.GitSHA
 	addFuncs(tr.funcMap, tr.store.FuncMap)
 
 	if config.Prefix != "" {
@@ -174,25 +250,66 @@ func NewTemplateResource(path string, config Config) (*TemplateResource, error)
 		tr.Prefix = "/" + tr.Prefix
 	}
 
+	if config.Strict {
+		tr.Strict = true
+	}
+	if tr.Strict {
+		addStrictFuncs(tr)
+	}
+
 	if len(config.PGPPrivateKey) > 0 {
 		tr.PGPPrivateKey = config.PGPPrivateKey
-		addCryptFuncs(&tr)
+		addCryptFuncs(tr)
 	}
 
 	if tr.Src == "" {
 		return nil, ErrEmptySrc
 	}
 
-	if tr.Uid == -1 {
-		tr.Uid = os.Geteuid()
+	if err := tr.resolveOwnership(); err != nil {
+		return nil, err
+	}
+
+	if err := tr.checkDestTemplate(); err != nil {
+		return nil, err
 	}
 
-	if tr.Gid == -1 {
-		tr.Gid = os.Getegid()
+	if err := checkValidator(tr.Validate); err != nil {
+		return nil, err
+	}
+
+	if err := tr.checkOnMissing(); err != nil {
+		return nil, err
+	}
+
+	if tr.Backup < 0 {
+		return nil, errors.New("backup 不能小于 0")
+	}
+	if tr.BackupDir == "" {
+		tr.BackupDir = defaultBackupDir(config)
+	}
+
+	if tr.reloadDebounce, tr.reloadMinInterval, err = reloadTiming(config, tr.ReloadDebounce, tr.ReloadMinInterval); err != nil {
+		return nil, err
+	}
+
+	tr.resourcePath = path
+	if tr.destTemplate != "" {
+		tr.statePath = destStatePath(config, path)
+		tr.renderedDests = loadRenderedDests(tr.statePath)
+	}
+	if err := tr.setupCommands(); err != nil {
+		return nil, err
 	}
 
 	tr.Src = filepath.Join(config.TemplateDir, tr.Src)
-	return &tr, nil
+
+	// 解析模板时会静态分析引用的键，自动补全 keys 中未声明的键
+	tr.declaredKeys = tr.Keys
+	if _, err := tr.parseTemplate(); err != nil {
+		log.Warning("无法分析模板 %s 中引用的键: %v", tr.Src, err)
+	}
+	return tr, nil
 }
 
 func addCryptFuncs(tr *TemplateResource) {
@@ -204,6 +321,7 @@ func addCryptFuncs(tr *TemplateResource) {
 				b, err = secconf.Decode([]byte(kv.Value), bytes.NewBuffer(tr.PGPPrivateKey))
 				if err == nil {
 					kv.Value = string(b)
+					tr.recordSecret(kv.Value)
 				}
 			}
 			return kv, err
@@ -217,6 +335,7 @@ func addCryptFuncs(tr *TemplateResource) {
 						return memkv.KVPairs(nil), err
 					}
 					kvs[i].Value = string(b)
+					tr.recordSecret(kvs[i].Value)
 				}
 			}
 			return kvs, err
@@ -227,6 +346,7 @@ func addCryptFuncs(tr *TemplateResource) {
 				var b []byte
 				b, err = secconf.Decode([]byte(v), bytes.NewBuffer(tr.PGPPrivateKey))
 				if err == nil {
+					tr.recordSecret(string(b))
 					return string(b), nil
 				}
 			}
@@ -241,6 +361,7 @@ func addCryptFuncs(tr *TemplateResource) {
 						return []string(nil), err
 					}
 					vs[i] = string(b)
+					tr.recordSecret(vs[i])
 				}
 			}
 			return vs, err
@@ -249,10 +370,12 @@ func addCryptFuncs(tr *TemplateResource) {
 }
 
 func (t *TemplateResource) setVars() error {
-	result, err := t.storeClient.GetValues(util.AppendPrefix(t.Prefix, t.Keys))
+	keys := util.AppendPrefix(t.Prefix, t.Keys)
+	result, err := t.getValues(append(keys, util.AppendPrefix(t.Prefix, t.optionalKeys)...))
 	if err != nil {
 		return err
 	}
+	t.missing = missingKeys(keys, result)
 
 	// 创建一个新的 map，仅包含键名
 	keysOnly := make([]string, 0, len(result))
@@ -263,42 +386,52 @@ func (t *TemplateResource) setVars() error {
 
 	t.store.Purge()
 
+	vars := make(map[string]string, len(result))
 	for k, v := range result {
-		t.store.Set(path.Join("/", strings.TrimPrefix(k, t.Prefix)), v)
+		key := path.Join("/", strings.TrimPrefix(k, t.Prefix))
+		t.store.Set(key, v)
+		vars[key] = v
 	}
+	t.fetched = vars
+	t.values = nestValues(vars)
 	return nil
 }
 
 func (t *TemplateResource) createStageFile() error {
 	log.Debug("使用源模板 " + t.Src)
 
-	if !util.IsFileExist(t.Src) {
-		return errors.New("缺少模板: " + t.Src)
-	}
-
-	// 读取模板内容以确保它正确包含所有行
-	templateContent, err := ioutil.ReadFile(t.Src)
+	tmpl, err := t.parseTemplate()
 	if err != nil {
-		return fmt.Errorf("无法读取模板 %s, %s", t.Src, err)
+		return err
 	}
 
-	tmpl, err := template.New(filepath.Base(t.Src)).Funcs(t.funcMap).Parse(string(templateContent))
+	// 在目标目录中创建临时文件以避免跨文件系统问题
+	dir, err := t.stageDir()
 	if err != nil {
-		return fmt.Errorf("无法处理模板 %s, %s", t.Src, err)
+		return err
 	}
-
-	// 在目标目录中创建临时文件以避免跨文件系统问题
-	temp, err := ioutil.TempFile(filepath.Dir(t.Dest), "."+filepath.Base(t.Dest))
+	temp, err := ioutil.TempFile(dir, "."+filepath.Base(t.Dest))
 	if err != nil {
 		return err
 	}
 
 	// 使用缓冲区执行模板并捕获所有行，包括空行
 	var buffer bytes.Buffer
-	if err = tmpl.Execute(&buffer, nil); err != nil {
-		temp.Close()
-		os.Remove(temp.Name())
-		return err
+	t.resetSecrets()
+	// on_missing = "empty" 且后端中的键不存在时生成空文件
+	if !t.renderEmpty {
+		if err = tmpl.Execute(&buffer, t.templateData()); err != nil {
+			temp.Close()
+			os.Remove(temp.Name())
+			return err
+		}
+	}
+	if t.Strict {
+		if err = checkStrictOutput(t.Src, buffer.Bytes()); err != nil {
+			temp.Close()
+			os.Remove(temp.Name())
+			return err
+		}
 	}
 
 	// 将缓冲区内容写入临时文件
@@ -314,12 +447,18 @@ func (t *TemplateResource) createStageFile() error {
 	os.Chmod(temp.Name(), t.FileMode)
 	os.Chown(temp.Name(), t.Uid, t.Gid)
 	t.StageFile = temp
+	stageFiles.add(temp.Name())
 	return nil
 }
 
 func (t *TemplateResource) sync() error {
     staged := t.StageFile.Name()
+    queued := false
     defer func() {
+        if queued {
+            return
+        }
+        stageFiles.done(staged)
         if !t.keepStageFile {
             os.Remove(staged)
         } else {
@@ -333,7 +472,17 @@ func (t *TemplateResource) sync() error {
         return fmt.Errorf("比较配置时出错: %v", err)
     }
 
+    if changed {
+        t.cycleChanged = true
+        if err := t.validate(); err != nil {
+            return err
+        }
+    }
+
     if t.noop {
+        if changed {
+            t.reportNoopChange(staged)
+        }
         log.Warning("Noop 模式已启用。%s 不会被修改", t.Dest)
         return nil
     }
@@ -345,6 +494,15 @@ func (t *TemplateResource) sync() error {
 
     log.Info("目标配置 %s 不同步", t.Dest)
 
+    if t.txn != nil {
+        // 资源组中的文件在所有成员处理完之后统一替换
+        if err := t.txn.queue(t, staged); err != nil {
+            return err
+        }
+        queued = true
+        return nil
+    }
+
     if err := t.performSync(staged); err != nil {
         return err
     }
@@ -363,15 +521,45 @@ func (t *TemplateResource) performSync(staged string) error {
         }
     }
 
+    var backup string
+    if t.Backup > 0 {
+        var err error
+        if backup, err = t.backupDest(); err != nil {
+            return err
+        }
+    }
+
     log.Debug("正在覆盖目标配置 %s", t.Dest)
     if err := t.replaceConfig(staged); err != nil {
         return err
     }
+    t.postSync()
+
+    if !t.syncOnly && t.ReloadCmd != "" && t.debounced() {
+        // 合并执行时 reload 和健康检查的结果在之后的回调中处理
+        t.scheduleReload(backup)
+        log.Info("目标配置 %s 已更新，reload 将合并执行", t.Dest)
+        return nil
+    }
 
     if !t.syncOnly && t.ReloadCmd != "" {
         log.Info("执行reload脚本: %s", t.ReloadCmd)
         if err := t.reload(); err != nil {
-            return fmt.Errorf("重新加载配置失败: %v", err)
+            err = fmt.Errorf("重新加载配置失败: %v", err)
+            if t.Backup > 0 {
+                return t.rollback(backup, err)
+            }
+            return err
+        }
+    }
+
+    if !t.syncOnly && t.HealthCheckCmd != "" {
+        if err := t.healthCheck(); err != nil {
+            err = fmt.Errorf("健康检查失败: %v", err)
+            if t.Backup > 0 {
+                return t.rollback(backup, err)
+            }
+            return err
         }
     }
 
@@ -407,6 +595,16 @@ func (t *TemplateResource) replaceConfig(staged string) error {
 }
 
 func (t *TemplateResource) sendSyncNotification() error {
+    return t.sendNotification("sync", "配置同步通知")
+}
+
+// sendNotification 发送一条关于当前 dest 的 event 类型的通知到 Loki
+func (t *TemplateResource) sendNotification(event, msg string) error {
+    return t.notify(t.Dest, event, msg)
+}
+
+// notify 发送一条关于 dest 的 event 类型的通知到 Loki
+func (t *TemplateResource) notify(dest, event, msg string) error {
     ip, err := getLocalIP()
     if err != nil {
         log.Warning("获取本地 IP 地址失败: %v", err)
@@ -423,10 +621,11 @@ func (t *TemplateResource) sendSyncNotification() error {
         "ip":       ip,
         "hostname": host,
         "template": t.Src,
-        "config":   t.Dest,
+        "config":   dest,
         "reload":   t.ReloadCmd,
+        "event":    event,
     }
-    logLine := fmt.Sprintf("IP: %s - 配置同步通知", ip)
+    logLine := fmt.Sprintf("IP: %s - %s", ip, msg)
 
     // 异步发送日志到Loki，不等待结果
     go SendLogToLoki("http://127.0.0.1:3100/loki/api/v1/push", labels, logLine)
@@ -435,22 +634,16 @@ func (t *TemplateResource) sendSyncNotification() error {
 }
 
 func (t *TemplateResource) check() error {
-	var cmdBuffer bytes.Buffer
-	data := make(map[string]string)
-	data["src"] = t.StageFile.Name()
-	tmpl, err := template.New("checkcmd").Parse(t.CheckCmd)
+	c, err := t.checkCommand()
 	if err != nil {
 		return err
 	}
-	if err := tmpl.Execute(&cmdBuffer, data); err != nil {
-		return err
-	}
-	return runCommand(cmdBuffer.String())
+	return t.runRecorded(c)
 }
 
 func (t *TemplateResource) reload() error {
 	log.Info("开始执行reload脚本: %s", t.ReloadCmd)
-	err := runCommand(t.ReloadCmd)
+	err := t.runRecorded(t.reloadCommand())
 	if err != nil {
         log.Error("Reload脚本执行失败: %v", err)
         return err
@@ -460,30 +653,48 @@ func (t *TemplateResource) reload() error {
 
 }
 
-func runCommand(cmd string) error {
-	log.Debug("运行脚本: " + cmd)
-	var c *exec.Cmd
-	if runtime.GOOS == "windows" {
-		c = exec.Command("cmd", "/C", cmd)
-	} else {
-		c = exec.Command("/bin/sh", "-c", cmd)
+func (t *TemplateResource) process() error {
+	t.cycleChanged = false
+	t.cycleStarted = time.Now()
+	err := t.processResource()
+	// 资源组中的资源在整组提交之后才知道最终结果，由资源组执行钩子
+	if t.txn == nil {
+		t.finishCycle(err)
 	}
+	return err
+}
 
-	output, err := c.CombinedOutput()
-	if err != nil {
-		log.Error("脚本执行失败: %s, 错误: %v", string(output), err)
-		return fmt.Errorf("脚本执行失败: %s, 错误: %v", string(output), err)
+func (t *TemplateResource) processResource() error {
+	// 先解析模板，模板变化后引用的键可能随之变化
+	if _, err := t.parseTemplate(); err != nil {
+		return err
 	}
-	log.Debug("脚本输出信息 >>>>\n%s", strings.TrimSpace(string(output)))
-	return nil
+	if err := t.setVars(); err != nil {
+		return err
+	}
+	if handled, err := t.handleMissing(t.missing); handled || err != nil {
+		return err
+	}
+	if t.destTemplate != "" {
+		return t.processDestTemplate()
+	}
+	return t.processDest()
 }
 
-func (t *TemplateResource) process() error {
+// processDest 将模板渲染到当前的 t.Dest 并同步
+func (t *TemplateResource) processDest() error {
+	defer destLocks.lock(t.Dest)()
 	if err := t.setFileMode(); err != nil {
 		return err
 	}
-	if err := t.setVars(); err != nil {
-		return err
+	// 键值、模板和目标文件都没有变化时不必重新生成暂存文件
+	digest := t.renderDigest()
+	if t.unchangedSinceRender(digest) {
+		log.Debug("%s 的输入未变化，跳过渲染", t.Dest)
+		return nil
+	}
+	if err := t.runHook(hookPreSync, t.PreSyncCmd); err != nil {
+		return fmt.Errorf("pre_sync 钩子执行失败: %v", err)
 	}
 	if err := t.createStageFile(); err != nil {
 		return err
@@ -491,6 +702,11 @@ func (t *TemplateResource) process() error {
 	if err := t.sync(); err != nil {
 		return err
 	}
+	if p := t.txn.pendingFor(t.Dest); p != nil {
+		p.digest = digest
+	} else {
+		t.recordRender(digest)
+	}
 	return nil
 }
 
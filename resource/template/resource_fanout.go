@@ -0,0 +1,228 @@
+package template
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/Risingtao/nacos-confd/log"
+	"github.com/Risingtao/nacos-confd/util"
+)
+
+// ForeachItem 是 foreach/foreach_service 展开时每个目标文件对应的数据，
+// 嵌入在 dest 模板和源模板的上下文中
+type ForeachItem struct {
+	Name      string        // 键的最后一段，例如 /sites/example.com 中的 example.com
+	Key       string        // 匹配到的完整键
+	Value     string        // 键的原始值
+	Index     int           // 在所有匹配项中的序号，按键排序
+	Instances []interface{} // foreach_service 时解析出的服务实例列表
+}
+
+// isFanout 报告资源是否需要按匹配项展开为多个目标文件
+func (t *TemplateResource) isFanout() bool {
+	return t.Foreach != "" || t.ForeachService != ""
+}
+
+// checkDestTemplate 在加载资源时校验 dest 与 foreach 的组合
+func (t *TemplateResource) checkDestTemplate() error {
+	if strings.Contains(t.Dest, "{{") {
+		t.destTemplate = t.Dest
+	}
+	if t.Foreach != "" && t.ForeachService != "" {
+		return errors.New("foreach 与 foreach_service 不能同时设置")
+	}
+	if t.isFanout() && t.destTemplate == "" {
+		return errors.New("使用 foreach 时 dest 必须是模板，例如 /etc/nginx/conf.d/{{.Name}}.conf")
+	}
+	if t.destTemplate != "" {
+		tmpl, err := t.parseDestTemplate()
+		if err != nil {
+			return err
+		}
+		t.destTmpl = tmpl
+	}
+	return nil
+}
+
+func (t *TemplateResource) parseDestTemplate() (*template.Template, error) {
+	tmpl := template.New("dest").Funcs(t.funcMap)
+	if t.Strict {
+		tmpl.Option("missingkey=error")
+	}
+	tmpl, err := tmpl.Parse(t.destTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("无法处理 dest 模板 %s, %s", t.destTemplate, err)
+	}
+	return tmpl, nil
+}
+
+// renderDest 使用给定的数据渲染 dest 模板，返回清理后的绝对路径
+func (t *TemplateResource) renderDest(data interface{}) (string, error) {
+	var buffer bytes.Buffer
+	if err := t.destTmpl.Execute(&buffer, data); err != nil {
+		return "", fmt.Errorf("无法渲染 dest 模板 %s, %s", t.destTemplate, err)
+	}
+	if t.Strict {
+		if err := checkStrictOutput(t.destTemplate, buffer.Bytes()); err != nil {
+			return "", err
+		}
+	}
+	dest := strings.TrimSpace(buffer.String())
+	if dest == "" {
+		return "", fmt.Errorf("dest 模板 %s 渲染结果为空", t.destTemplate)
+	}
+	if !filepath.IsAbs(dest) {
+		return "", fmt.Errorf("dest 模板 %s 渲染结果 %s 不是绝对路径", t.destTemplate, dest)
+	}
+	return filepath.Clean(dest), nil
+}
+
+// foreachItems 按 foreach 或 foreach_service 模式从已获取的键中匹配展开项
+func (t *TemplateResource) foreachItems() ([]*ForeachItem, error) {
+	pattern := t.Foreach
+	if t.ForeachService != "" {
+		pattern = t.ForeachService
+	}
+	kvs, err := t.store.GetAll(path.Join("/", pattern))
+	if err != nil {
+		return nil, fmt.Errorf("无效的 foreach 模式 %s: %w", pattern, err)
+	}
+
+	items := make([]*ForeachItem, 0, len(kvs))
+	for i, kv := range kvs {
+		item := &ForeachItem{
+			Name:  path.Base(kv.Key),
+			Key:   kv.Key,
+			Value: kv.Value,
+			Index: i,
+		}
+		if t.ForeachService != "" {
+			if err := json.Unmarshal([]byte(kv.Value), &item.Instances); err != nil {
+				return nil, fmt.Errorf("无法解析服务 %s 的实例列表: %w", kv.Key, err)
+			}
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// processDestTemplate 处理模板化的 dest：为每个匹配项（未设置 foreach 时只有一项）
+// 渲染出目标路径并同步，然后删除上一轮生成但本轮已不存在的目标文件
+func (t *TemplateResource) processDestTemplate() error {
+	items := []*ForeachItem{nil}
+	if t.isFanout() {
+		var err error
+		if items, err = t.foreachItems(); err != nil {
+			return err
+		}
+	}
+
+	defer func() {
+		t.Dest = t.destTemplate
+		t.item = nil
+	}()
+
+	var lastErr error
+	destFailed := false
+	rendered := make(map[string]bool, len(items))
+	for _, item := range items {
+		t.item = item
+		dest, err := t.renderDest(t.templateData())
+		if err != nil {
+			if item == nil {
+				return err
+			}
+			lastErr = err
+			destFailed = true
+			log.Error("%v", err)
+			continue
+		}
+		if item != nil && rendered[dest] {
+			lastErr = fmt.Errorf("foreach 项 %s 渲染出重复的 dest %s", item.Key, dest)
+			log.Error("%v", lastErr)
+			continue
+		}
+		rendered[dest] = true
+
+		t.Dest = dest
+		if err := t.processDest(); err != nil {
+			if item == nil {
+				return err
+			}
+			lastErr = fmt.Errorf("foreach 项 %s - 处理出错: %w", item.Key, err)
+			log.Error("%v", lastErr)
+		}
+	}
+
+	// dest 渲染失败的项仍然存在，但无法知道它对应哪个文件，这一轮保留之前所有的 dest，
+	// 以免删除它仍在使用的文件
+	if destFailed {
+		for dest := range t.renderedDests {
+			rendered[dest] = true
+		}
+	}
+	removed := t.removeStaleDests(rendered)
+	if removed {
+		t.cycleChanged = true
+	}
+	t.setRenderedDests(rendered)
+	t.forgetRenders(rendered)
+
+	if removed && t.txn != nil {
+		t.txn.removed = true
+	} else if removed && !t.noop && !t.syncOnly && t.ReloadCmd != "" {
+		if err := t.requestReload(); err != nil {
+			return fmt.Errorf("重新加载配置失败: %v", err)
+		}
+	}
+	return lastErr
+}
+
+// removeStaleDests 删除上一轮渲染过、本轮已不再生成的目标文件，返回是否有文件被删除。
+// 上一轮的文件在 confd 重启后从状态文件中读取
+func (t *TemplateResource) removeStaleDests(current map[string]bool) bool {
+	var stale []string
+	for dest := range t.renderedDests {
+		if !current[dest] {
+			stale = append(stale, dest)
+		}
+	}
+	sort.Strings(stale)
+
+	removed := false
+	for _, dest := range stale {
+		if t.removeStaleDest(dest, current) {
+			removed = true
+		}
+	}
+	return removed
+}
+
+// removeStaleDest 删除一个不再生成的目标文件，无法删除时将其保留在 current 中
+func (t *TemplateResource) removeStaleDest(dest string, current map[string]bool) bool {
+	defer destLocks.lock(dest)()
+	if !util.IsFileExist(dest) {
+		return false
+	}
+	if t.noop {
+		reportNoopRemoval(dest)
+		log.Warning("Noop 模式已启用。%s 已不再生成，但不会被删除", dest)
+		current[dest] = true
+		return false
+	}
+	if err := os.Remove(dest); err != nil {
+		log.Error("删除目标配置 %s 失败: %v", dest, err)
+		current[dest] = true
+		return false
+	}
+	log.Info("目标配置 %s 已不再生成，已删除", dest)
+	return true
+}
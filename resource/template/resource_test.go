@@ -0,0 +1,36 @@
+package template
+
+import (
+	"os"
+	"testing"
+)
+
+func BenchmarkCreateStageFile(b *testing.B) {
+	for _, bc := range []struct {
+		name string
+		miss bool
+	}{
+		{name: "cache hit"},
+		{name: "cache miss", miss: true},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			_, t := benchResource(b)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if bc.miss {
+					t.parsed = nil
+				}
+				if err := t.createStageFile(); err != nil {
+					b.Fatal(err)
+				}
+				b.StopTimer()
+				staged := t.StageFile.Name()
+				t.StageFile.Close()
+				stageFiles.done(staged)
+				os.Remove(staged)
+				b.StartTimer()
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package template
+
+import (
+	"os"
+	"sync"
+)
+
+// stageFiles 记录已创建、尚未删除或替换到目标位置的暂存文件
+var stageFiles = &stageFileSet{names: make(map[string]bool)}
+
+type stageFileSet struct {
+	mu    sync.Mutex
+	names map[string]bool
+}
+
+func (s *stageFileSet) add(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.names[name] = true
+}
+
+// done 表示暂存文件已被删除、替换或有意保留
+func (s *stageFileSet) done(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.names, name)
+}
+
+// RemoveStageFiles 删除所有仍在使用中的暂存文件，返回删除的数量。
+// 用于处理器未能在超时内结束、需要强制退出的情况，正常结束时不会留下暂存文件
+func RemoveStageFiles() int {
+	stageFiles.mu.Lock()
+	defer stageFiles.mu.Unlock()
+	n := 0
+	for name := range stageFiles.names {
+		if err := os.Remove(name); err == nil {
+			n++
+		}
+		delete(stageFiles.names, name)
+	}
+	return n
+}
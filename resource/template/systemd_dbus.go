@@ -0,0 +1,62 @@
+package template
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+)
+
+// errBusUnavailable 表示无法连接或认证到系统总线，此时改用 systemctl
+var errBusUnavailable = errors.New("无法连接到 D-Bus 系统总线")
+
+// systemdBusReload 通过系统总线调用 systemd 的 ReloadUnit，并等待 reload 任务结束。
+// timeout 大于零时限制整个过程的时间
+func systemdBusReload(unit string, timeout time.Duration) (string, error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	conn, err := dbus.NewSystemConnectionContext(ctx)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errBusUnavailable, err)
+	}
+	defer conn.Close()
+
+	prop, err := conn.GetUnitPropertyContext(ctx, unit, "LoadState")
+	if err != nil {
+		return "", fmt.Errorf("查询 systemd unit %s 失败: %v", unit, busError(ctx, err))
+	}
+	if state, _ := prop.Value.Value().(string); state == "not-found" {
+		return "LoadState=" + state, fmt.Errorf("systemd unit %s 不存在", unit)
+	}
+
+	done := make(chan string, 1)
+	job, err := conn.ReloadUnitContext(ctx, unit, "replace", done)
+	if err != nil {
+		return "", fmt.Errorf("重新加载 systemd unit %s 失败: %v", unit, busError(ctx, err))
+	}
+	select {
+	case result := <-done:
+		out := fmt.Sprintf("systemd unit %s reload 任务 %d 结果: %s", unit, job, result)
+		if result != "done" {
+			return out, fmt.Errorf("重新加载 systemd unit %s 失败: 任务结果为 %s", unit, result)
+		}
+		return out, nil
+	case <-ctx.Done():
+		return "", fmt.Errorf("等待 systemd unit %s 的 reload 任务结束失败: %v", unit, busError(ctx, ctx.Err()))
+	}
+}
+
+// busError 将超时转换为更明确的错误
+func busError(ctx context.Context, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return errors.New("执行超时")
+	}
+	return err
+}
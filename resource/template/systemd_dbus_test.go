@@ -0,0 +1,200 @@
+package template
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	godbus "github.com/godbus/dbus/v5"
+)
+
+const dbusSystemBusEnv = "DBUS_SYSTEM_BUS_ADDRESS"
+
+// fakeSystemd 通过 godbus 导出 systemd 的 Manager 和 unit 属性，只回应 systemdBusReload 用到的调用
+type fakeSystemd struct {
+	conn      *godbus.Conn
+	loadState string
+	result    string // JobRemoved 中的任务结果，为空时不发送信号
+}
+
+func (s *fakeSystemd) ReloadUnit(name, mode string) (godbus.ObjectPath, *godbus.Error) {
+	job := godbus.ObjectPath("/org/freedesktop/systemd1/job/7")
+	if s.result != "" {
+		go func() {
+			// 先结束的其他任务不应被当作 reload 的结果
+			s.conn.Emit("/org/freedesktop/systemd1", "org.freedesktop.systemd1.Manager.JobRemoved",
+				uint32(6), godbus.ObjectPath("/org/freedesktop/systemd1/job/6"), name, "failed")
+			s.conn.Emit("/org/freedesktop/systemd1", "org.freedesktop.systemd1.Manager.JobRemoved",
+				uint32(7), job, name, s.result)
+		}()
+	}
+	return job, nil
+}
+
+type fakeUnitProperties struct {
+	s *fakeSystemd
+}
+
+func (p fakeUnitProperties) Get(iface, name string) (godbus.Variant, *godbus.Error) {
+	return godbus.MakeVariant(p.s.loadState), nil
+}
+
+// startBus 启动一个私有的 dbus-daemon，并在测试期间把系统总线地址指向它
+func startBus(t *testing.T) string {
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("未找到 dbus-daemon")
+	}
+	dir, err := ioutil.TempDir("", "confd-dbus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := "unix:path=" + filepath.Join(dir, "bus.sock")
+	conf := filepath.Join(dir, "bus.conf")
+	ioutil.WriteFile(conf, []byte(`<!DOCTYPE busconfig PUBLIC "-//freedesktop//DTD D-Bus Bus Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/busconfig.dtd">
+<busconfig>
+  <type>custom</type>
+  <listen>`+addr+`</listen>
+  <auth>EXTERNAL</auth>
+  <policy context="default">
+    <allow send_destination="*" eavesdrop="true"/>
+    <allow eavesdrop="true"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>
+`), 0644)
+	cmd := exec.Command(daemon, "--config-file="+conf, "--nofork", "--print-address")
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+		os.RemoveAll(dir)
+	})
+	// 打印出地址时总线已经可以连接
+	buf := make([]byte, 256)
+	if _, err := out.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	setBusAddress(t, addr)
+	return addr
+}
+
+// setBusAddress 在测试期间把系统总线地址设置为 addr
+func setBusAddress(t *testing.T, addr string) {
+	old, had := os.LookupEnv(dbusSystemBusEnv)
+	os.Setenv(dbusSystemBusEnv, addr)
+	t.Cleanup(func() {
+		if had {
+			os.Setenv(dbusSystemBusEnv, old)
+		} else {
+			os.Unsetenv(dbusSystemBusEnv)
+		}
+	})
+}
+
+func startFakeSystemd(t *testing.T, s *fakeSystemd) {
+	addr := startBus(t)
+	conn, err := godbus.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if err := conn.Auth(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Hello(); err != nil {
+		t.Fatal(err)
+	}
+	s.conn = conn
+	conn.Export(s, "/org/freedesktop/systemd1", "org.freedesktop.systemd1.Manager")
+	conn.Export(fakeUnitProperties{s}, "/org/freedesktop/systemd1/unit/app_2eservice", "org.freedesktop.DBus.Properties")
+	if reply, err := conn.RequestName("org.freedesktop.systemd1", godbus.NameFlagDoNotQueue); err != nil || reply != godbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("RequestName = %v, %v", reply, err)
+	}
+}
+
+func TestSystemdBusReload(t *testing.T) {
+	tests := []struct {
+		name      string
+		loadState string
+		result    string
+		timeout   time.Duration
+		wantErr   string
+	}{
+		{name: "done", loadState: "loaded", result: "done"},
+		{name: "unit not found", loadState: "not-found", result: "done", wantErr: "不存在"},
+		{name: "job failed", loadState: "loaded", result: "failed", wantErr: "任务结果为 failed"},
+		{name: "timeout", loadState: "loaded", timeout: 100 * time.Millisecond, wantErr: "执行超时"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			startFakeSystemd(t, &fakeSystemd{loadState: tt.loadState, result: tt.result})
+			timeout := tt.timeout
+			if timeout == 0 {
+				timeout = 5 * time.Second
+			}
+			out, err := systemdReload("app.service", timeout)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want error containing %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else if !strings.Contains(out, "任务 7 结果: done") {
+				t.Errorf("output = %q", out)
+			}
+		})
+	}
+}
+
+func TestSystemdReloadFallsBackToSystemctl(t *testing.T) {
+	setBusAddress(t, "unix:path="+filepath.Join(os.TempDir(), "confd-no-such-bus.sock"))
+	if _, err := systemdBusReload("app.service", time.Second); !errors.Is(err, errBusUnavailable) {
+		t.Fatalf("error = %v, want errBusUnavailable", err)
+	}
+
+	// 用假的 systemctl 代替真实命令，记录调用的参数
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	script := fmt.Sprintf("#!/bin/sh\necho \"$@\" >> %s\n[ \"$1\" = show ] && echo LoadState=loaded\n[ \"$1\" = reload ] && sleep \"${SLEEP:-0}\"\nexit 0\n", calls)
+	if err := ioutil.WriteFile(filepath.Join(dir, "systemctl"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	oldPath := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+oldPath)
+	t.Cleanup(func() {
+		os.Setenv("PATH", oldPath)
+		os.Unsetenv("SLEEP")
+	})
+
+	if _, err := systemdReload("app.service", 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(calls)
+	if want := "show -p LoadState app.service\nreload app.service\n"; string(data) != want {
+		t.Errorf("systemctl calls = %q, want %q", data, want)
+	}
+
+	// reload_timeout 同样限制 systemctl
+	os.Setenv("SLEEP", "5")
+	start := time.Now()
+	if _, err := systemdReload("app.service", 100*time.Millisecond); err == nil || !strings.Contains(err.Error(), "执行超时") {
+		t.Fatalf("error = %v, want timeout", err)
+	}
+	if d := time.Since(start); d > 3*time.Second {
+		t.Errorf("systemdReload took %s, want the timeout to apply", d)
+	}
+}
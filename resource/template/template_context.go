@@ -0,0 +1,139 @@
+package template
+
+import (
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// valueKeyInMap 是某个键同时作为值和其他键的前缀时，在 Values 中保存其自身值的键名
+const valueKeyInMap = "_value"
+
+// TemplateContext 是执行模板时的上下文（模板中的 .）。
+// 使用 foreach 展开时嵌入当前匹配项，因此 .Name、.Key、.Value 等可以直接使用。
+type TemplateContext struct {
+	*ForeachItem
+	Host     HostInfo
+	Resource ResourceInfo
+	Render   RenderInfo
+	Values   map[string]interface{}
+}
+
+// HostInfo 描述运行 confd 的主机
+type HostInfo struct {
+	Name   string
+	IPs    []string
+	Labels map[string]string
+}
+
+// ResourceInfo 描述当前正在渲染的模板资源
+type ResourceInfo struct {
+	Src    string
+	Dest   string
+	Keys   []string
+	Prefix string
+}
+
+// RenderInfo 描述本次渲染
+type RenderInfo struct {
+	Timestamp time.Time
+	Version   string
+	GitSHA    string
+}
+
+// templateData 返回执行源模板和 dest 模板时使用的上下文
+func (t *TemplateResource) templateData() interface{} {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	keys := make([]string, len(t.Keys))
+	copy(keys, t.Keys)
+	return &TemplateContext{
+		ForeachItem: t.item,
+		Host: HostInfo{
+			Name:   host,
+			IPs:    hostIPs(),
+			Labels: t.hostLabels,
+		},
+		Resource: ResourceInfo{
+			Src:    t.Src,
+			Dest:   t.Dest,
+			Keys:   keys,
+			Prefix: t.Prefix,
+		},
+		Render: RenderInfo{
+			Timestamp: time.Now(),
+			Version:   t.version,
+			GitSHA:    t.gitSHA,
+		},
+		Values: t.values,
+	}
+}
+
+// hostIPs 返回本机所有非回环地址，IPv4 在前，按字符串排序
+func hostIPs() []string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var v4, v6 []string
+	for _, address := range addrs {
+		ipnet, ok := address.(*net.IPNet)
+		if !ok || ipnet.IP.IsLoopback() {
+			continue
+		}
+		if ipnet.IP.To4() != nil {
+			v4 = append(v4, ipnet.IP.String())
+		} else {
+			v6 = append(v6, ipnet.IP.String())
+		}
+	}
+	sort.Strings(v4)
+	sort.Strings(v6)
+	return append(v4, v6...)
+}
+
+// nestValues 将以 / 分隔的键转换为嵌套的 map，例如 /app/db/host 对应
+// Values.app.db.host。若某个键既有值又是其他键的前缀，其值保存在 _value 下。
+func nestValues(kvs map[string]string) map[string]interface{} {
+	keys := make([]string, 0, len(kvs))
+	for k := range kvs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	root := make(map[string]interface{})
+	for _, k := range keys {
+		parts := strings.Split(strings.Trim(path.Clean("/"+k), "/"), "/")
+		if len(parts) == 1 && parts[0] == "" {
+			root[valueKeyInMap] = kvs[k]
+			continue
+		}
+		node := root
+		for _, p := range parts[:len(parts)-1] {
+			switch child := node[p].(type) {
+			case map[string]interface{}:
+				node = child
+			case string:
+				m := map[string]interface{}{valueKeyInMap: child}
+				node[p] = m
+				node = m
+			default:
+				m := make(map[string]interface{})
+				node[p] = m
+				node = m
+			}
+		}
+		leaf := parts[len(parts)-1]
+		if m, ok := node[leaf].(map[string]interface{}); ok {
+			m[valueKeyInMap] = kvs[k]
+		} else {
+			node[leaf] = kvs[k]
+		}
+	}
+	return root
+}
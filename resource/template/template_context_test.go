@@ -0,0 +1,53 @@
+package template
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNestValues(t *testing.T) {
+	got := nestValues(map[string]string{
+		"/app/db/host": "db",
+		"/app/db":      "dsn",
+		"/app/name":    "web",
+		"/app.yaml":    "a: 1",
+	})
+	want := map[string]interface{}{
+		"app": map[string]interface{}{
+			"db":   map[string]interface{}{"host": "db", valueKeyInMap: "dsn"},
+			"name": "web",
+		},
+		"app.yaml": "a: 1",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("nestValues = %#v, want %#v", got, want)
+	}
+}
+
+func TestTemplateContext(t *testing.T) {
+	e := newTestEnv(t, map[string]string{"/app/db/host": "db", "/app/db": "dsn", "/app.yaml": "a: 1"})
+	e.config.Labels = map[string]string{"env": "prod"}
+	e.config.Version = "1.2.3"
+	e.writeTemplate("t.tmpl", "{{.Host.Name}}|{{.Host.Labels.env}}\n"+
+		"{{.Resource.Src}}|{{.Resource.Dest}}|{{.Resource.Keys}}|{{.Resource.Prefix}}\n"+
+		"{{.Render.Version}}|{{not .Render.Timestamp.IsZero}}\n"+
+		`{{.Values.app.db.host}}|{{index .Values.app.db "_value"}}|{{index .Values "app.yaml"}}`)
+	e.writeResource("t.toml", "[template]\nsrc = \"t.tmpl\"\ndest = \"{{out}}/t.conf\"\nkeys = [\"/app/db/host\", \"/app/db\", \"/app.yaml\"]\n")
+	if err := e.resource("t.toml").process(); err != nil {
+		t.Fatal(err)
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := host + "|prod\n" +
+		filepath.Join(e.config.TemplateDir, "t.tmpl") + "|" + filepath.Join(e.out, "t.conf") + "|[/app/db/host /app/db /app.yaml]|/\n" +
+		"1.2.3|true\n" +
+		"db|dsn|a: 1"
+	if got := e.readOut("t.conf"); got != want {
+		t.Errorf("t.conf =\n%s\nwant\n%s", got, want)
+	}
+}
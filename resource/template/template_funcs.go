@@ -54,6 +54,8 @@ func newFuncMap() map[string]interface{} {
 	m["mul"] = func(a, b int) int { return a * b }
 	m["seq"] = Seq                     // 生成序列（可能是数字序列）
 	m["atoi"] = strconv.Atoi           // 将字符串转换为整数
+	addFuncs(m, newSprigFuncMap())     // 添加 sprig 风格的扩展函数
+	addFuncs(m, newFormatFuncMap())    // 添加配置格式的解析和编码函数
 	return m                           // 返回函数映射
 }
 
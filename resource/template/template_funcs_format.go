@@ -0,0 +1,336 @@
+package template
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Risingtao/nacos-confd/depends/toml"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// newFormatFuncMap 返回用于解析和生成常见配置格式的模板函数。
+// 所有编码函数对 map 的键按字母序输出，保证重复渲染得到逐字节相同的结果。
+func newFormatFuncMap() map[string]interface{} {
+	m := make(map[string]interface{})
+	m["fromYaml"] = FromYaml
+	m["fromYamlArray"] = FromYamlArray
+	m["fromToml"] = FromToml
+	m["fromProperties"] = FromProperties
+	m["fromIni"] = FromIni
+	m["toToml"] = ToToml
+	m["toProperties"] = ToProperties
+	m["toJsonPretty"] = ToJsonPretty
+	return m
+}
+
+// FromYaml 将 YAML 字符串解析为 map[string]interface{}
+func FromYaml(data string) (map[string]interface{}, error) {
+	var ret map[string]interface{}
+	err := yaml.Unmarshal([]byte(data), &ret)
+	return ret, err
+}
+
+// FromYamlArray 将 YAML 字符串解析为 []interface{}
+func FromYamlArray(data string) ([]interface{}, error) {
+	var ret []interface{}
+	err := yaml.Unmarshal([]byte(data), &ret)
+	return ret, err
+}
+
+// FromToml 使用内置的 toml 包将 TOML 字符串解析为 map[string]interface{}
+func FromToml(data string) (map[string]interface{}, error) {
+	var ret map[string]interface{}
+	_, err := toml.Decode(data, &ret)
+	return ret, err
+}
+
+// FromProperties 解析 Java .properties 格式的字符串，支持 = : 和空白分隔符、
+// # 和 ! 注释、行尾反斜杠续行以及 \uXXXX 等转义
+func FromProperties(data string) (map[string]string, error) {
+	ret := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNo := 0
+	var logical string
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimLeft(scanner.Text(), " \t\f")
+		if logical == "" && (line == "" || line[0] == '#' || line[0] == '!') {
+			continue
+		}
+		if continued(line) {
+			logical += line[:len(line)-1]
+			continue
+		}
+		logical += line
+		key, value, err := splitProperty(logical)
+		if err != nil {
+			return nil, fmt.Errorf("第 %d 行: %v", lineNo, err)
+		}
+		ret[key] = value
+		logical = ""
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if logical != "" {
+		key, value, err := splitProperty(logical)
+		if err != nil {
+			return nil, fmt.Errorf("第 %d 行: %v", lineNo, err)
+		}
+		ret[key] = value
+	}
+	return ret, nil
+}
+
+// continued 判断行尾是否为未被转义的反斜杠
+func continued(line string) bool {
+	n := 0
+	for i := len(line) - 1; i >= 0 && line[i] == '\\'; i-- {
+		n++
+	}
+	return n%2 == 1
+}
+
+// splitProperty 将一条逻辑行拆分为键和值，并处理转义
+func splitProperty(line string) (string, string, error) {
+	end := len(line)
+	for i := 0; i < len(line); i++ {
+		if line[i] == '\\' {
+			i++
+			continue
+		}
+		if line[i] == '=' || line[i] == ':' || line[i] == ' ' || line[i] == '\t' || line[i] == '\f' {
+			end = i
+			break
+		}
+	}
+	key, err := unescapeProperty(line[:end])
+	if err != nil {
+		return "", "", err
+	}
+	rest := strings.TrimLeft(line[end:], " \t\f")
+	if rest != "" && (rest[0] == '=' || rest[0] == ':') {
+		rest = strings.TrimLeft(rest[1:], " \t\f")
+	}
+	value, err := unescapeProperty(rest)
+	if err != nil {
+		return "", "", err
+	}
+	return key, value, nil
+}
+
+func unescapeProperty(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' || i == len(s)-1 {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		switch s[i] {
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 'f':
+			b.WriteByte('\f')
+		case 'u':
+			if i+4 >= len(s) {
+				return "", fmt.Errorf("无效的 unicode 转义: %s", s[i-1:])
+			}
+			r, err := strconv.ParseUint(s[i+1:i+5], 16, 32)
+			if err != nil {
+				return "", fmt.Errorf("无效的 unicode 转义: %s", s[i-1:i+5])
+			}
+			b.WriteRune(rune(r))
+			i += 4
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// FromIni 解析 INI 格式的字符串。节外的键直接位于顶层，每个 [section] 解析为一个
+// 嵌套的 map，因此可以写作 {{ (fromIni $v).server.port }}
+func FromIni(data string) (map[string]interface{}, error) {
+	ret := make(map[string]interface{})
+	current := ret
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' {
+			if line[len(line)-1] != ']' {
+				return nil, fmt.Errorf("第 %d 行: 节名缺少 ]", lineNo)
+			}
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			if name == "" {
+				return nil, fmt.Errorf("第 %d 行: 节名为空", lineNo)
+			}
+			section, ok := ret[name].(map[string]interface{})
+			if !ok {
+				if _, exists := ret[name]; exists {
+					return nil, fmt.Errorf("第 %d 行: 节 %s 与同名的键冲突", lineNo, name)
+				}
+				section = make(map[string]interface{})
+				ret[name] = section
+			}
+			current = section
+			continue
+		}
+		i := strings.IndexAny(line, "=:")
+		if i < 0 {
+			return nil, fmt.Errorf("第 %d 行: 缺少 = 或 :", lineNo)
+		}
+		key := strings.TrimSpace(line[:i])
+		if key == "" {
+			return nil, fmt.Errorf("第 %d 行: 键为空", lineNo)
+		}
+		current[key] = unquoteIniValue(strings.TrimSpace(line[i+1:]))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func unquoteIniValue(v string) string {
+	if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+		return v[1 : len(v)-1]
+	}
+	return v
+}
+
+// ToToml 使用内置的 toml 包将值编码为 TOML 字符串
+func ToToml(v interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(v); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// ToJsonPretty 将值编码为两格缩进的 JSON 字符串
+func ToJsonPretty(v interface{}) (string, error) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// ToProperties 将值编码为 .properties 格式。嵌套的 map 以点号连接键名，
+// 列表以 [i] 下标展开，输出按键排序
+func ToProperties(v interface{}) (string, error) {
+	flat := make(map[string]string)
+	if err := flattenProperties("", reflect.ValueOf(v), flat); err != nil {
+		return "", err
+	}
+	keys := make([]string, 0, len(flat))
+	for k := range flat {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(escapeProperty(k, true))
+		b.WriteByte('=')
+		b.WriteString(escapeProperty(flat[k], false))
+		b.WriteByte('\n')
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+func flattenProperties(prefix string, v reflect.Value, out map[string]string) error {
+	for v.IsValid() && (v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr) && !v.IsNil() {
+		v = v.Elem()
+	}
+	switch {
+	case !v.IsValid() || v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr:
+		if prefix != "" {
+			out[prefix] = ""
+		}
+	case v.Kind() == reflect.Map:
+		for _, k := range v.MapKeys() {
+			key := fmt.Sprint(k.Interface())
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			if err := flattenProperties(key, v.MapIndex(k), out); err != nil {
+				return err
+			}
+		}
+	case v.Kind() == reflect.Slice || v.Kind() == reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			out[prefix] = string(v.Bytes())
+			break
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := flattenProperties(fmt.Sprintf("%s[%d]", prefix, i), v.Index(i), out); err != nil {
+				return err
+			}
+		}
+	default:
+		if prefix == "" {
+			return fmt.Errorf("toProperties 需要 map 类型的参数，得到 %s", v.Kind())
+		}
+		out[prefix] = ToString(v.Interface())
+	}
+	return nil
+}
+
+// escapeProperty 按 .properties 规则转义键或值
+func escapeProperty(s string, isKey bool) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch r {
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '=', ':', '#', '!':
+			if isKey || i == 0 {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(r)
+		case ' ':
+			if isKey || i == 0 {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(r)
+		default:
+			if r < 0x20 || r == utf8.RuneError {
+				fmt.Fprintf(&b, `\u%04x`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	return b.String()
+}
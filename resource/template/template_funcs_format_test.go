@@ -0,0 +1,168 @@
+package template
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFromProperties(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"separators", "a=1\nb:2\nc 3\nd\t=\t4\ne = 5", map[string]string{"a": "1", "b": "2", "c": "3", "d": "4", "e": "5"}, false},
+		{"comments and blank lines", "# c\n! c\n\n  \n  a = 1", map[string]string{"a": "1"}, false},
+		{"empty value", "a=\nb", map[string]string{"a": "", "b": ""}, false},
+		{"value keeps separators", "url=http://h:80/a=b", map[string]string{"url": "http://h:80/a=b"}, false},
+		{"escaped separators in key", `a\=b\:c\ d=1`, map[string]string{"a=b:c d": "1"}, false},
+		{"escapes", `a=\t\n\r\f\\\q`, map[string]string{"a": "\t\n\r\f\\q"}, false},
+		{"unicode escape", `a=\u4e2d\u6587\u0041`, map[string]string{"a": "中文A"}, false},
+		{"continuation", "a=1,\\\n    2,\\\n\t3\nb=4", map[string]string{"a": "1,2,3", "b": "4"}, false},
+		{"escaped backslash is not continuation", "a=x\\\\\nb=y", map[string]string{"a": `x\`, "b": "y"}, false},
+		{"continuation at end of input", "a=1\\", map[string]string{"a": "1"}, false},
+		{"comment inside continuation", "a=1\\\n# not a comment", map[string]string{"a": "1# not a comment"}, false},
+		{"later key wins", "a=1\na=2", map[string]string{"a": "2"}, false},
+		{"trailing whitespace kept in value", "a=1  ", map[string]string{"a": "1  "}, false},
+		{"short unicode escape", `a=\u12`, nil, true},
+		{"invalid unicode escape", `a=\uzzzz`, nil, true},
+		{"invalid unicode escape in key", `\uzzzz=1`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FromProperties(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FromProperties(%q) error = %v, wantErr %v", tt.data, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FromProperties(%q) = %q, want %q", tt.data, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromIni(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    map[string]interface{}
+		wantErr bool
+	}{
+		{
+			name: "sections",
+			data: "top=1\n[server]\nport = 80\nhost: h\n[db]\nname=x",
+			want: map[string]interface{}{
+				"top":    "1",
+				"server": map[string]interface{}{"port": "80", "host": "h"},
+				"db":     map[string]interface{}{"name": "x"},
+			},
+		},
+		{
+			name: "comments and quotes",
+			data: "; c\n# c\n[s]\na = \"quoted value\"\nb = 'single'\nc = \"unbalanced'\nd =",
+			want: map[string]interface{}{
+				"s": map[string]interface{}{"a": "quoted value", "b": "single", "c": `"unbalanced'`, "d": ""},
+			},
+		},
+		{
+			name: "repeated section merges",
+			data: "[s]\na=1\n[t]\nb=2\n[ s ]\nc=3",
+			want: map[string]interface{}{
+				"s": map[string]interface{}{"a": "1", "c": "3"},
+				"t": map[string]interface{}{"b": "2"},
+			},
+		},
+		{
+			name: "value keeps later separators",
+			data: "url=http://h:80/?a=b",
+			want: map[string]interface{}{"url": "http://h:80/?a=b"},
+		},
+		{name: "missing bracket", data: "[s\na=1", wantErr: true},
+		{name: "empty section name", data: "[ ]", wantErr: true},
+		{name: "missing separator", data: "[s]\njunk", wantErr: true},
+		{name: "empty key", data: "=1", wantErr: true},
+		{name: "section conflicts with key", data: "s=1\n[s]", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FromIni(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FromIni(%q) error = %v, wantErr %v", tt.data, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FromIni(%q) = %v, want %v", tt.data, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToProperties(t *testing.T) {
+	tests := []struct {
+		name    string
+		v       interface{}
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "nested and sorted",
+			v: map[string]interface{}{
+				"b": map[string]interface{}{"y": 2, "x": "1"},
+				"a": []interface{}{"p", true},
+				"c": nil,
+			},
+			want: "a[0]=p\na[1]=true\nb.x=1\nb.y=2\nc=",
+		},
+		{
+			name: "escapes",
+			v:    map[string]string{"k=e y:#!": " lead=in:side\n\ttab\\", "u": "\x01"},
+			want: `k\=e\ y\:\#\!=\ lead=in:side\n\ttab\\` + "\n" + `u=\u0001`,
+		},
+		{
+			name: "leading comment chars in value",
+			v:    map[string]string{"a": "#x", "b": "!y", "c": "=z"},
+			want: `a=\#x` + "\n" + `b=\!y` + "\n" + `c=\=z`,
+		},
+		{
+			name: "bytes",
+			v:    map[string]interface{}{"a": []byte("raw")},
+			want: "a=raw",
+		},
+		{name: "scalar", v: "x", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ToProperties(tt.v)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ToProperties(%v) error = %v, wantErr %v", tt.v, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ToProperties(%v) = %q, want %q", tt.v, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestPropertiesRoundTrip 检查 ToProperties 的输出能被 FromProperties 原样读回
+func TestPropertiesRoundTrip(t *testing.T) {
+	in := map[string]string{
+		"plain":       "value",
+		"spaced key":  "  leading and trailing  ",
+		"sep=:":       "a=b:c",
+		"#comment":    "!bang",
+		"multi\nline": "x\ny\r\n",
+		"unicode":     "中文\u0001",
+		"backslash\\": "ends with \\",
+	}
+	out, err := ToProperties(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := FromProperties(out)
+	if err != nil {
+		t.Fatalf("FromProperties(%q): %v", out, err)
+	}
+	if !reflect.DeepEqual(got, in) {
+		t.Errorf("round trip = %q, want %q\nproperties:\n%s", got, in, out)
+	}
+}
@@ -0,0 +1,709 @@
+package template
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// newSprigFuncMap 返回一组与 Helm/sprig 命名兼容的扩展模板函数。
+// 与 newFuncMap 中已有函数同名的（如 add、replace、contains、join、split、trimSuffix）
+// 保留原有语义，这里不做覆盖。
+func newSprigFuncMap() map[string]interface{} {
+	m := make(map[string]interface{})
+
+	// 默认值与条件
+	m["default"] = Default
+	m["empty"] = Empty
+	m["coalesce"] = Coalesce
+	m["ternary"] = Ternary
+
+	// 编码
+	m["toJson"] = ToJson
+	m["toYaml"] = ToYaml
+	m["b64enc"] = Base64Encode
+	m["b64dec"] = Base64Decode
+	m["sha1sum"] = Sha1Sum
+	m["sha256sum"] = Sha256Sum
+	m["uuidv4"] = UUIDv4
+
+	// 字符串
+	m["quote"] = Quote
+	m["squote"] = Squote
+	m["indent"] = Indent
+	m["nindent"] = Nindent
+	m["trim"] = strings.TrimSpace
+	m["trimAll"] = func(cutset, s string) string { return strings.Trim(s, cutset) }
+	m["trimPrefix"] = func(prefix, s string) string { return strings.TrimPrefix(s, prefix) }
+	m["upper"] = strings.ToUpper
+	m["lower"] = strings.ToLower
+	m["title"] = strings.Title
+	m["repeat"] = func(count int, s string) string { return strings.Repeat(s, count) }
+	m["substr"] = Substr
+	m["trunc"] = Trunc
+	m["hasPrefix"] = func(prefix, s string) bool { return strings.HasPrefix(s, prefix) }
+	m["hasSuffix"] = func(suffix, s string) bool { return strings.HasSuffix(s, suffix) }
+	m["splitList"] = func(sep, s string) []string { return strings.Split(s, sep) }
+	m["toString"] = ToString
+	m["toStrings"] = ToStrings
+
+	// 正则
+	m["regexMatch"] = RegexMatch
+	m["regexFind"] = RegexFind
+	m["regexFindAll"] = RegexFindAll
+	m["regexReplaceAll"] = RegexReplaceAll
+	m["regexReplaceAllLiteral"] = RegexReplaceAllLiteral
+	m["regexSplit"] = RegexSplit
+
+	// 日期
+	m["now"] = time.Now
+	m["date"] = Date
+	m["dateInZone"] = DateInZone
+	m["toDate"] = ToDate
+	m["unixEpoch"] = func(t time.Time) string { return strconv.FormatInt(t.Unix(), 10) }
+
+	// 数值转换与数学运算
+	m["int"] = ToInt
+	m["int64"] = ToInt64
+	m["float64"] = ToFloat64
+	m["add1"] = func(a interface{}) int64 { return ToInt64(a) + 1 }
+	m["max"] = Max
+	m["min"] = Min
+	m["addf"] = func(a, b interface{}) float64 { return ToFloat64(a) + ToFloat64(b) }
+	m["subf"] = func(a, b interface{}) float64 { return ToFloat64(a) - ToFloat64(b) }
+	m["mulf"] = func(a, b interface{}) float64 { return ToFloat64(a) * ToFloat64(b) }
+	m["divf"] = func(a, b interface{}) float64 { return ToFloat64(a) / ToFloat64(b) }
+	m["maxf"] = Maxf
+	m["minf"] = Minf
+	m["floor"] = func(a interface{}) float64 { return math.Floor(ToFloat64(a)) }
+	m["ceil"] = func(a interface{}) float64 { return math.Ceil(ToFloat64(a)) }
+	m["round"] = Round
+
+	// 列表
+	m["list"] = List
+	m["first"] = First
+	m["last"] = Last
+	m["rest"] = Rest
+	m["initial"] = Initial
+	m["append"] = Append
+	m["prepend"] = Prepend
+	m["concat"] = Concat
+	m["uniq"] = Uniq
+	m["has"] = Has
+	m["without"] = Without
+	m["compact"] = Compact
+	m["sortAlpha"] = SortAlpha
+
+	// 字典
+	m["dict"] = CreateMap
+	m["set"] = Set
+	m["unset"] = Unset
+	m["hasKey"] = HasKey
+	m["keys"] = Keys
+	m["values"] = Values
+	m["pick"] = Pick
+	m["omit"] = Omit
+	m["merge"] = Merge
+	return m
+}
+
+// Default 在 given 为空值时返回 def，对应 sprig 的 `default "x" .Value`
+func Default(def interface{}, given ...interface{}) interface{} {
+	if len(given) == 0 || Empty(given[0]) {
+		return def
+	}
+	return given[0]
+}
+
+// Empty 判断值是否为对应类型的零值，nil、空字符串、空集合均视为空
+func Empty(given interface{}) bool {
+	v := reflect.ValueOf(given)
+	if !v.IsValid() {
+		return true
+	}
+	switch v.Kind() {
+	case reflect.Array, reflect.Chan, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	case reflect.Struct:
+		return reflect.DeepEqual(given, reflect.Zero(v.Type()).Interface())
+	}
+	return false
+}
+
+// Coalesce 返回第一个非空的参数
+func Coalesce(values ...interface{}) interface{} {
+	for _, v := range values {
+		if !Empty(v) {
+			return v
+		}
+	}
+	return nil
+}
+
+// Ternary 在 cond 为真时返回 vt，否则返回 vf
+func Ternary(vt, vf interface{}, cond bool) interface{} {
+	if cond {
+		return vt
+	}
+	return vf
+}
+
+// ToJson 将值编码为紧凑的 JSON 字符串
+func ToJson(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// ToYaml 将值编码为两格缩进的 YAML 字符串，map 的键按字母序输出，并去掉末尾换行
+func ToYaml(v interface{}) (string, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(v); err != nil {
+		return "", err
+	}
+	if err := enc.Close(); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// Sha1Sum 返回字符串的 SHA1 十六进制摘要
+func Sha1Sum(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// Sha256Sum 返回字符串的 SHA256 十六进制摘要
+func Sha256Sum(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// UUIDv4 生成一个随机的 RFC 4122 版本 4 UUID
+func UUIDv4() (string, error) {
+	var u [16]byte
+	if _, err := rand.Read(u[:]); err != nil {
+		return "", err
+	}
+	u[6] = (u[6] & 0x0f) | 0x40
+	u[8] = (u[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:]), nil
+}
+
+// Quote 为每个参数加上双引号并以空格连接
+func Quote(values ...interface{}) string {
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		if v != nil {
+			out = append(out, strconv.Quote(ToString(v)))
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+// Squote 为每个参数加上单引号并以空格连接
+func Squote(values ...interface{}) string {
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		if v != nil {
+			out = append(out, "'"+ToString(v)+"'")
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+// Indent 在每一行前添加 spaces 个空格
+func Indent(spaces int, s string) string {
+	pad := strings.Repeat(" ", spaces)
+	return pad + strings.Replace(s, "\n", "\n"+pad, -1)
+}
+
+// Nindent 与 Indent 相同，但会在开头额外添加一个换行
+func Nindent(spaces int, s string) string {
+	return "\n" + Indent(spaces, s)
+}
+
+// Substr 按 rune 截取 [start, end) 区间，end 小于 0 表示截取到末尾
+func Substr(start, end int, s string) string {
+	r := []rune(s)
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 || end > len(r) {
+		end = len(r)
+	}
+	if start > end {
+		return ""
+	}
+	return string(r[start:end])
+}
+
+// Trunc 截取前 n 个字符，n 为负数时保留末尾 -n 个字符
+func Trunc(n int, s string) string {
+	r := []rune(s)
+	if n < 0 && len(r)+n > 0 {
+		return string(r[len(r)+n:])
+	}
+	if n >= 0 && len(r) > n {
+		return string(r[:n])
+	}
+	return s
+}
+
+// ToString 将任意值转换为字符串
+func ToString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case []byte:
+		return string(s)
+	case error:
+		return s.Error()
+	case fmt.Stringer:
+		return s.String()
+	case nil:
+		return ""
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// ToStrings 将列表中的每个元素转换为字符串
+func ToStrings(list interface{}) []string {
+	l := toList(list)
+	out := make([]string, len(l))
+	for i, v := range l {
+		out[i] = ToString(v)
+	}
+	return out
+}
+
+// RegexMatch 判断字符串是否匹配正则
+func RegexMatch(regex, s string) (bool, error) {
+	return regexp.MatchString(regex, s)
+}
+
+// RegexFind 返回第一个匹配的子串
+func RegexFind(regex, s string) (string, error) {
+	r, err := regexp.Compile(regex)
+	if err != nil {
+		return "", err
+	}
+	return r.FindString(s), nil
+}
+
+// RegexFindAll 返回最多 n 个匹配的子串，n 为 -1 表示全部
+func RegexFindAll(regex, s string, n int) ([]string, error) {
+	r, err := regexp.Compile(regex)
+	if err != nil {
+		return nil, err
+	}
+	return r.FindAllString(s, n), nil
+}
+
+// RegexReplaceAll 替换所有匹配，repl 中可以使用 $1 等分组引用
+func RegexReplaceAll(regex, s, repl string) (string, error) {
+	r, err := regexp.Compile(regex)
+	if err != nil {
+		return "", err
+	}
+	return r.ReplaceAllString(s, repl), nil
+}
+
+// RegexReplaceAllLiteral 按字面量替换所有匹配
+func RegexReplaceAllLiteral(regex, s, repl string) (string, error) {
+	r, err := regexp.Compile(regex)
+	if err != nil {
+		return "", err
+	}
+	return r.ReplaceAllLiteralString(s, repl), nil
+}
+
+// RegexSplit 按正则切分字符串，最多返回 n 段，n 为 -1 表示不限
+func RegexSplit(regex, s string, n int) ([]string, error) {
+	r, err := regexp.Compile(regex)
+	if err != nil {
+		return nil, err
+	}
+	return r.Split(s, n), nil
+}
+
+// Date 使用 Go 的时间布局格式化时间，date 可以是 time.Time、*time.Time 或 Unix 秒数
+func Date(layout string, date interface{}) string {
+	return DateInZone(layout, date, "Local")
+}
+
+// DateInZone 在指定时区内格式化时间，时区无效时使用 UTC
+func DateInZone(layout string, date interface{}, zone string) string {
+	var t time.Time
+	switch d := date.(type) {
+	case time.Time:
+		t = d
+	case *time.Time:
+		t = *d
+	default:
+		t = time.Unix(ToInt64(date), 0)
+	}
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		loc = time.UTC
+	}
+	return t.In(loc).Format(layout)
+}
+
+// ToDate 按布局把字符串解析为本地时间
+func ToDate(layout, s string) (time.Time, error) {
+	return time.ParseInLocation(layout, s, time.Local)
+}
+
+// ToInt64 将数字或数字字符串转换为 int64，无法转换时返回 0
+func ToInt64(v interface{}) int64 {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return int64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return int64(rv.Float())
+	case reflect.Bool:
+		if rv.Bool() {
+			return 1
+		}
+		return 0
+	case reflect.String:
+		s := strings.TrimSpace(rv.String())
+		if i, err := strconv.ParseInt(s, 0, 64); err == nil {
+			return i
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return int64(f)
+		}
+	}
+	return 0
+}
+
+// ToInt 将数字或数字字符串转换为 int
+func ToInt(v interface{}) int {
+	return int(ToInt64(v))
+}
+
+// ToFloat64 将数字或数字字符串转换为 float64，无法转换时返回 0
+func ToFloat64(v interface{}) float64 {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	case reflect.Bool:
+		if rv.Bool() {
+			return 1
+		}
+		return 0
+	case reflect.String:
+		if f, err := strconv.ParseFloat(strings.TrimSpace(rv.String()), 64); err == nil {
+			return f
+		}
+	}
+	return 0
+}
+
+// Max 返回整数参数中的最大值
+func Max(a interface{}, rest ...interface{}) int64 {
+	out := ToInt64(a)
+	for _, v := range rest {
+		if i := ToInt64(v); i > out {
+			out = i
+		}
+	}
+	return out
+}
+
+// Min 返回整数参数中的最小值
+func Min(a interface{}, rest ...interface{}) int64 {
+	out := ToInt64(a)
+	for _, v := range rest {
+		if i := ToInt64(v); i < out {
+			out = i
+		}
+	}
+	return out
+}
+
+// Maxf 返回浮点参数中的最大值
+func Maxf(a interface{}, rest ...interface{}) float64 {
+	out := ToFloat64(a)
+	for _, v := range rest {
+		out = math.Max(out, ToFloat64(v))
+	}
+	return out
+}
+
+// Minf 返回浮点参数中的最小值
+func Minf(a interface{}, rest ...interface{}) float64 {
+	out := ToFloat64(a)
+	for _, v := range rest {
+		out = math.Min(out, ToFloat64(v))
+	}
+	return out
+}
+
+// Round 按 precision 位小数四舍五入
+func Round(a interface{}, precision int) float64 {
+	p := math.Pow(10, float64(precision))
+	return math.Round(ToFloat64(a)*p) / p
+}
+
+// toList 将任意切片或数组转换为 []interface{}，非列表值返回 nil
+func toList(list interface{}) []interface{} {
+	if l, ok := list.([]interface{}); ok {
+		return l
+	}
+	v := reflect.ValueOf(list)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil
+	}
+	out := make([]interface{}, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		out[i] = v.Index(i).Interface()
+	}
+	return out
+}
+
+// List 由参数构造一个列表
+func List(values ...interface{}) []interface{} {
+	return values
+}
+
+// First 返回列表的第一个元素
+func First(list interface{}) interface{} {
+	l := toList(list)
+	if len(l) == 0 {
+		return nil
+	}
+	return l[0]
+}
+
+// Last 返回列表的最后一个元素
+func Last(list interface{}) interface{} {
+	l := toList(list)
+	if len(l) == 0 {
+		return nil
+	}
+	return l[len(l)-1]
+}
+
+// Rest 返回除第一个元素外的其余元素
+func Rest(list interface{}) []interface{} {
+	l := toList(list)
+	if len(l) == 0 {
+		return []interface{}{}
+	}
+	return l[1:]
+}
+
+// Initial 返回除最后一个元素外的其余元素
+func Initial(list interface{}) []interface{} {
+	l := toList(list)
+	if len(l) == 0 {
+		return []interface{}{}
+	}
+	return l[:len(l)-1]
+}
+
+// Append 返回在列表末尾追加 v 后的新列表
+func Append(list interface{}, v interface{}) []interface{} {
+	l := toList(list)
+	out := make([]interface{}, 0, len(l)+1)
+	return append(append(out, l...), v)
+}
+
+// Prepend 返回在列表开头插入 v 后的新列表
+func Prepend(list interface{}, v interface{}) []interface{} {
+	l := toList(list)
+	out := make([]interface{}, 0, len(l)+1)
+	return append(append(out, v), l...)
+}
+
+// Concat 将多个列表拼接为一个
+func Concat(lists ...interface{}) []interface{} {
+	var out []interface{}
+	for _, l := range lists {
+		out = append(out, toList(l)...)
+	}
+	return out
+}
+
+// Uniq 去除列表中的重复元素，保留首次出现的顺序
+func Uniq(list interface{}) []interface{} {
+	var out []interface{}
+	for _, v := range toList(list) {
+		if !inList(out, v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// Has 判断列表中是否包含 needle
+func Has(needle interface{}, list interface{}) bool {
+	return inList(toList(list), needle)
+}
+
+// Without 返回去除指定元素后的列表
+func Without(list interface{}, omit ...interface{}) []interface{} {
+	var out []interface{}
+	for _, v := range toList(list) {
+		if !inList(omit, v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// Compact 去除列表中的空值
+func Compact(list interface{}) []interface{} {
+	var out []interface{}
+	for _, v := range toList(list) {
+		if !Empty(v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// SortAlpha 将列表元素转换为字符串后按字母序排序
+func SortAlpha(list interface{}) []string {
+	out := ToStrings(list)
+	sort.Strings(out)
+	return out
+}
+
+func inList(list []interface{}, needle interface{}) bool {
+	for _, v := range list {
+		if reflect.DeepEqual(v, needle) {
+			return true
+		}
+	}
+	return false
+}
+
+// Set 设置字典中的键并返回该字典
+func Set(d map[string]interface{}, key string, value interface{}) map[string]interface{} {
+	d[key] = value
+	return d
+}
+
+// Unset 删除字典中的键并返回该字典
+func Unset(d map[string]interface{}, key string) map[string]interface{} {
+	delete(d, key)
+	return d
+}
+
+// HasKey 判断字典中是否存在键
+func HasKey(d map[string]interface{}, key string) bool {
+	_, ok := d[key]
+	return ok
+}
+
+// Keys 返回一个或多个字典的全部键，按字母序排序
+func Keys(dicts ...map[string]interface{}) []string {
+	var out []string
+	for _, d := range dicts {
+		for k := range d {
+			out = append(out, k)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Values 返回字典的全部值，按键的字母序排列
+func Values(d map[string]interface{}) []interface{} {
+	keys := Keys(d)
+	out := make([]interface{}, len(keys))
+	for i, k := range keys {
+		out[i] = d[k]
+	}
+	return out
+}
+
+// Pick 返回只包含指定键的新字典
+func Pick(d map[string]interface{}, keys ...string) map[string]interface{} {
+	out := make(map[string]interface{}, len(keys))
+	for _, k := range keys {
+		if v, ok := d[k]; ok {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// Omit 返回去除指定键后的新字典
+func Omit(d map[string]interface{}, keys ...string) map[string]interface{} {
+	skip := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		skip[k] = true
+	}
+	out := make(map[string]interface{}, len(d))
+	for k, v := range d {
+		if !skip[k] {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// Merge 将 src 中 dst 不存在的键递归合并到 dst，dst 中已有的值优先
+func Merge(dst map[string]interface{}, srcs ...map[string]interface{}) (map[string]interface{}, error) {
+	if dst == nil {
+		return nil, errors.New("merge 的目标字典不能为空")
+	}
+	for _, src := range srcs {
+		for k, sv := range src {
+			dv, ok := dst[k]
+			if !ok {
+				dst[k] = sv
+				continue
+			}
+			dm, dok := dv.(map[string]interface{})
+			sm, sok := sv.(map[string]interface{})
+			if dok && sok {
+				if _, err := Merge(dm, sm); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return dst, nil
+}
@@ -0,0 +1,202 @@
+package template
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+	"text/template"
+	"time"
+)
+
+// execSprig 用 sprig 函数执行模板文本
+func execSprig(text string, data interface{}) (string, error) {
+	tmpl, err := template.New("t").Funcs(newSprigFuncMap()).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, data)
+	return buf.String(), err
+}
+
+func TestSprigFuncs(t *testing.T) {
+	data := map[string]interface{}{
+		"empty": "",
+		"str":   "hello",
+		"zero":  0,
+		"nil":   nil,
+		"list":  []string{"b", "a", "b", ""},
+		"ints":  []int{3, 1, 2},
+		"dict":  map[string]interface{}{"a": 1, "b": map[string]interface{}{"c": 2}},
+		"time":  time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		// 默认值与条件
+		{"default empty", `{{default "x" .empty}}`, "x"},
+		{"default set", `{{default "x" .str}}`, "hello"},
+		{"default zero", `{{.zero | default 5}}`, "5"},
+		{"default nil", `{{default "x" .nil}}`, "x"},
+		{"empty", `{{empty .empty}} {{empty .str}} {{empty .zero}} {{empty .dict}}`, "true false true false"},
+		{"coalesce", `{{coalesce .empty .zero "c" "d"}}`, "c"},
+		{"coalesce none", `{{coalesce .empty .nil}}`, "<no value>"},
+		{"ternary", `{{ternary "y" "n" true}} {{true | ternary "y" "n"}} {{ternary "y" "n" false}}`, "y y n"},
+
+		// 编码
+		{"toJson", `{{toJson .dict}}`, `{"a":1,"b":{"c":2}}`},
+		{"toYaml", `{{toYaml .dict}}`, "a: 1\nb:\n  c: 2"},
+		{"b64", `{{b64enc "hello"}} {{b64enc "hello" | b64dec}}`, "aGVsbG8= hello"},
+		{"sha1sum", `{{sha1sum "abc"}}`, "a9993e364706816aba3e25717850c26c9cd0d89d"},
+		{"sha256sum", `{{sha256sum "abc"}}`, "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+
+		// 字符串
+		{"quote", `{{quote "a" 1 .nil "b\"c"}}`, `"a" "1" "b\"c"`},
+		{"squote", `{{squote "a" 1}}`, `'a' '1'`},
+		{"indent", `{{indent 2 "a\nb"}}`, "  a\n  b"},
+		{"nindent", `{{nindent 2 "a"}}`, "\n  a"},
+		{"trim", `[{{trim "  a  "}}][{{trimAll "$" "$$a$"}}][{{trimPrefix "ab" "abc"}}]`, "[a][a][c]"},
+		{"case", `{{upper "ab"}} {{lower "AB"}} {{title "ab cd"}}`, "AB ab Ab Cd"},
+		{"repeat", `{{repeat 3 "ab"}}`, "ababab"},
+		{"substr", `{{substr 1 3 "héllo"}}|{{substr 2 -1 "hello"}}|{{substr 4 2 "hello"}}|{{substr -1 99 "hi"}}`, "él|llo||hi"},
+		{"trunc", `{{trunc 2 "hello"}}|{{trunc -2 "hello"}}|{{trunc 10 "hi"}}|{{trunc -10 "hi"}}`, "he|lo|hi|hi"},
+		{"prefix suffix", `{{hasPrefix "he" "hello"}} {{hasSuffix "lo" "hello"}} {{hasPrefix "x" "hello"}}`, "true true false"},
+		{"splitList", `{{splitList "," "a,b,,c"}}`, "[a b  c]"},
+		{"toString", `{{toString 1.5}} {{toString .nil}}|{{toStrings .ints}}`, "1.5 |[3 1 2]"},
+
+		// 正则
+		{"regexMatch", `{{regexMatch "^h.*o$" "hello"}} {{regexMatch "^x" "hello"}}`, "true false"},
+		{"regexFind", `{{regexFind "[0-9]+" "ab12cd34"}}`, "12"},
+		{"regexFindAll", `{{regexFindAll "[0-9]+" "ab12cd34ef5" -1}} {{regexFindAll "[0-9]+" "ab12cd34ef5" 2}}`, "[12 34 5] [12 34]"},
+		{"regexReplaceAll", `{{regexReplaceAll "(a)(b)" "abab" "$2$1"}}`, "baba"},
+		{"regexReplaceAllLiteral", `{{regexReplaceAllLiteral "a" "abab" "$1"}}`, "$1b$1b"},
+		{"regexSplit", `{{regexSplit "[,;]" "a,b;c" -1}} {{regexSplit "[,;]" "a,b;c" 2}}`, "[a b c] [a b;c]"},
+
+		// 日期
+		{"dateInZone", `{{dateInZone "2006-01-02 15:04:05" .time "UTC"}}`, "2024-01-02 03:04:05"},
+		{"dateInZone unix", `{{dateInZone "2006-01-02" 86400 "UTC"}}`, "1970-01-02"},
+		{"dateInZone invalid zone", `{{dateInZone "15:04" .time "Nowhere/Invalid"}}`, "03:04"},
+		{"unixEpoch", `{{unixEpoch .time}}`, "1704164645"},
+		{"toDate", `{{(toDate "2006-01-02" "2024-03-04").Day}}`, "4"},
+
+		// 数值
+		{"int", `{{int "42"}} {{int "0x10"}} {{int 3.9}} {{int "2.5"}} {{int "x"}} {{int true}}`, "42 16 3 2 0 1"},
+		{"int64 float64", `{{int64 "7"}} {{float64 "1.5"}} {{float64 "x"}} {{float64 2}}`, "7 1.5 0 2"},
+		{"add1", `{{add1 "1"}}`, "2"},
+		{"max min", `{{max 1 "5" 3}} {{min 4 "2" 3}}`, "5 2"},
+		{"float math", `{{addf 1 "0.5"}} {{subf 3 0.5}} {{mulf 2 "1.5"}} {{divf 3 2}}`, "1.5 2.5 3 1.5"},
+		{"maxf minf", `{{maxf 1.5 "2.5" 2}} {{minf 1.5 "0.5"}}`, "2.5 0.5"},
+		{"floor ceil round", `{{floor 1.7}} {{ceil "1.2"}} {{round 3.14159 2}} {{round 2.5 0}}`, "1 2 3.14 3"},
+
+		// 列表
+		{"list", `{{list 1 "a" 2}}`, "[1 a 2]"},
+		{"first last", `{{first .list}} {{last .ints}} {{first .empty}}`, "b 2 <no value>"},
+		{"rest initial", `{{rest .ints}} {{initial .ints}} {{rest .nil}}`, "[1 2] [3 1] []"},
+		{"append prepend", `{{append .ints 4}} {{prepend .ints 0}}`, "[3 1 2 4] [0 3 1 2]"},
+		{"concat", `{{concat .ints (list "x") .nil}}`, "[3 1 2 x]"},
+		{"uniq", `{{uniq .list}}`, "[b a ]"},
+		{"has", `{{has "a" .list}} {{has "z" .list}} {{has 1 .ints}}`, "true false true"},
+		{"without", `{{without .list "b"}}`, "[a ]"},
+		{"compact", `{{compact .list}}`, "[b a b]"},
+		{"sortAlpha", `{{sortAlpha .list}} {{sortAlpha .ints}}`, "[ a b b] [1 2 3]"},
+
+		// 字典
+		{"dict", `{{$d := dict "b" 2 "a" 1}}{{keys $d}} {{values $d}}`, "[a b] [1 2]"},
+		{"set unset", `{{$d := dict "a" 1}}{{$_ := set $d "b" 2}}{{$_ := unset $d "a"}}{{keys $d}}`, "[b]"},
+		{"hasKey", `{{hasKey .dict "a"}} {{hasKey .dict "z"}}`, "true false"},
+		{"keys multi", `{{keys (dict "b" 1) (dict "a" 2)}}`, "[a b]"},
+		{"pick omit", `{{keys (pick .dict "a" "z")}} {{keys (omit .dict "a")}}`, "[a] [b]"},
+		{"merge", `{{$d := dict "a" 0 "b" (dict "d" 3)}}{{toJson (merge $d .dict)}}`, `{"a":0,"b":{"c":2,"d":3}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := execSprig(tt.text, data)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.text, err)
+			}
+			if got != tt.want {
+				t.Errorf("%s = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSprigFuncErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+	}{
+		{"b64dec invalid", `{{b64dec "!!!"}}`},
+		{"regexMatch invalid", `{{regexMatch "(" "a"}}`},
+		{"regexFind invalid", `{{regexFind "(" "a"}}`},
+		{"regexFindAll invalid", `{{regexFindAll "(" "a" -1}}`},
+		{"regexReplaceAll invalid", `{{regexReplaceAll "(" "a" "b"}}`},
+		{"regexReplaceAllLiteral invalid", `{{regexReplaceAllLiteral "(" "a" "b"}}`},
+		{"regexSplit invalid", `{{regexSplit "(" "a" -1}}`},
+		{"toDate invalid", `{{toDate "2006-01-02" "not a date"}}`},
+		{"toJson unsupported", `{{toJson .ch}}`},
+		{"merge nil", `{{merge .nilmap (dict "a" 1)}}`},
+	}
+	data := map[string]interface{}{
+		"ch":     make(chan int),
+		"nilmap": map[string]interface{}(nil),
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := execSprig(tt.text, data); err == nil {
+				t.Errorf("%s = %q, want error", tt.text, got)
+			}
+		})
+	}
+}
+
+func TestUUIDv4(t *testing.T) {
+	re := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		u, err := UUIDv4()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !re.MatchString(u) {
+			t.Fatalf("UUIDv4() = %q, not a version 4 UUID", u)
+		}
+		if seen[u] {
+			t.Fatalf("UUIDv4() returned %q twice", u)
+		}
+		seen[u] = true
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	type pair struct{ A, B int }
+	var nilPtr *int
+	tests := []struct {
+		v    interface{}
+		want bool
+	}{
+		{nil, true},
+		{"", true},
+		{"a", false},
+		{0, true},
+		{uint(0), true},
+		{uint(1), false},
+		{0.0, true},
+		{1.5, false},
+		{false, true},
+		{true, false},
+		{[]int{}, true},
+		{[]int{0}, false},
+		{map[string]int{}, true},
+		{nilPtr, true},
+		{pair{}, true},
+		{pair{A: 1}, false},
+	}
+	for _, tt := range tests {
+		if got := Empty(tt.v); got != tt.want {
+			t.Errorf("Empty(%#v) = %v, want %v", tt.v, got, tt.want)
+		}
+	}
+}
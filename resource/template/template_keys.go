@@ -0,0 +1,284 @@
+package template
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/Risingtao/nacos-confd/log"
+)
+
+// keyFuncKind 描述模板函数的参数如何解释为存储中的键
+type keyFuncKind int
+
+const (
+	keyExact   keyFuncKind = iota // 参数是一个具体的键
+	keyPattern                    // 参数是 path.Match 风格的模式
+	keyDir                        // 参数是一个目录前缀
+)
+
+// keyFuncs 列出所有以键作为第一个参数的模板函数
+var keyFuncs = map[string]keyFuncKind{
+	"exists": keyExact,
+	"get":    keyExact,
+	"getv":   keyExact,
+	"cget":   keyExact,
+	"cgetv":  keyExact,
+	"gets":   keyPattern,
+	"getvs":  keyPattern,
+	"cgets":  keyPattern,
+	"cgetvs": keyPattern,
+	"ls":     keyDir,
+	"lsdir":  keyDir,
+}
+
+// templateKeyRef 是模板中对某个键的一次字面量引用
+type templateKeyRef struct {
+	Func     string
+	Key      string
+	Kind     keyFuncKind
+	Optional bool // 模板自己处理键不存在的情况：exists 或带默认值的 getv
+}
+
+// templateKeyRefs 返回模板及其引用的片段中所有字面量键引用
+func templateKeyRefs(tmpl *template.Template) []templateKeyRef {
+	var refs []templateKeyRef
+	walkTemplates(tmpl, func(node parse.Node) {
+		n, ok := node.(*parse.PipeNode)
+		if !ok {
+			return
+		}
+		for i, cmd := range n.Cmds {
+			// 管道中前一个命令的结果作为最后一个参数传入
+			piped := 0
+			if i > 0 {
+				piped = 1
+			}
+			switch {
+			case len(cmd.Args) >= 2:
+				addKeyRef(cmd.Args[0], cmd.Args[1], len(cmd.Args)-1+piped, &refs)
+			case len(cmd.Args) == 1 && i > 0 && len(n.Cmds[i-1].Args) == 1:
+				// 处理 {{ "/key" | getv }} 这种管道写法
+				addKeyRef(cmd.Args[0], n.Cmds[i-1].Args[0], 1, &refs)
+			}
+		}
+	})
+	return refs
+}
+
+// walkTemplates 对模板以及从它可达的片段（通过 template 动作或以字面量名称调用 include）
+// 语法树中的每个节点调用 visit。include 的名称不是字面量时无法确定引用了哪些片段，
+// 此时遍历模板集合中的所有模板
+func walkTemplates(tmpl *template.Template, visit func(parse.Node)) {
+	seen := make(map[string]bool)
+	dynamic := false
+	queue := []string{tmpl.Name()}
+	walk := func(t *template.Template) {
+		if t == nil || t.Tree == nil || t.Tree.Root == nil {
+			return
+		}
+		walkNode(t.Tree.Root, func(node parse.Node) {
+			switch n := node.(type) {
+			case *parse.TemplateNode:
+				queue = append(queue, n.Name)
+			case *parse.CommandNode:
+				if ident, ok := n.Args[0].(*parse.IdentifierNode); ok && ident.Ident == "include" {
+					if len(n.Args) >= 2 {
+						if str, ok := n.Args[1].(*parse.StringNode); ok {
+							queue = append(queue, str.Text)
+							break
+						}
+					}
+					dynamic = true
+				}
+			}
+			visit(node)
+		})
+	}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		walk(tmpl.Lookup(name))
+	}
+	if dynamic {
+		for _, t := range tmpl.Templates() {
+			if !seen[t.Name()] {
+				seen[t.Name()] = true
+				walk(t)
+			}
+		}
+	}
+}
+
+// walkNode 先序遍历模板语法树
+func walkNode(node parse.Node, visit func(parse.Node)) {
+	if node == nil {
+		return
+	}
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		visit(n)
+		for _, c := range n.Nodes {
+			walkNode(c, visit)
+		}
+	case *parse.ActionNode:
+		visit(n)
+		walkNode(n.Pipe, visit)
+	case *parse.IfNode:
+		visit(n)
+		walkBranch(&n.BranchNode, visit)
+	case *parse.RangeNode:
+		visit(n)
+		walkBranch(&n.BranchNode, visit)
+	case *parse.WithNode:
+		visit(n)
+		walkBranch(&n.BranchNode, visit)
+	case *parse.TemplateNode:
+		visit(n)
+		walkNode(n.Pipe, visit)
+	case *parse.ChainNode:
+		visit(n)
+		walkNode(n.Node, visit)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		visit(n)
+		for _, cmd := range n.Cmds {
+			walkNode(cmd, visit)
+		}
+	case *parse.CommandNode:
+		visit(n)
+		for _, arg := range n.Args {
+			walkNode(arg, visit)
+		}
+	default:
+		visit(n)
+	}
+}
+
+func walkBranch(n *parse.BranchNode, visit func(parse.Node)) {
+	walkNode(n.Pipe, visit)
+	walkNode(n.List, visit)
+	walkNode(n.ElseList, visit)
+}
+
+// addKeyRef 在 fn 是键函数且 arg 是字符串字面量时记录一次引用，nargs 是 fn 实际收到的参数个数
+func addKeyRef(fn, arg parse.Node, nargs int, refs *[]templateKeyRef) {
+	ident, ok := fn.(*parse.IdentifierNode)
+	if !ok {
+		return
+	}
+	kind, ok := keyFuncs[ident.Ident]
+	if !ok {
+		return
+	}
+	str, ok := arg.(*parse.StringNode)
+	if !ok {
+		return
+	}
+	optional := ident.Ident == "exists" || (ident.Ident == "getv" && nargs >= 2)
+	*refs = append(*refs, templateKeyRef{Func: ident.Ident, Key: str.Text, Kind: kind, Optional: optional})
+}
+
+// hasGlobMeta 判断键中是否包含 path.Match 的通配符
+func hasGlobMeta(key string) bool {
+	return strings.ContainsAny(key, `*?[\`)
+}
+
+// updateKeys 从模板中发现引用的键，与 conf.d 中声明的键合并为 t.Keys，
+// 并对无法解析的引用发出警告。用 exists 检查过、或只通过带默认值的 getv 引用的键放入 t.optionalKeys：
+// 它们同样会被获取和监听，但不存在时不算缺失，由模板自己处理
+func (t *TemplateResource) updateKeys(tmpl *template.Template) {
+	refs := templateKeyRefs(tmpl)
+	if t.Foreach != "" {
+		refs = append(refs, templateKeyRef{Func: "foreach", Key: t.Foreach, Kind: keyPattern})
+	}
+	if t.ForeachService != "" {
+		refs = append(refs, templateKeyRef{Func: "foreach_service", Key: t.ForeachService, Kind: keyPattern})
+	}
+
+	keys := append([]string(nil), t.declaredKeys...)
+	declared := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		declared[path.Join("/", k)] = true
+	}
+
+	// {{if exists "/k"}}{{getv "/k"}}{{end}} 这样先检查再读取的键同样是可选的
+	guarded := make(map[string]bool)
+	for _, ref := range refs {
+		if ref.Func == "exists" {
+			guarded[path.Join("/", ref.Key)] = true
+		}
+	}
+
+	var added, optional []string
+	var unresolved []templateKeyRef
+	for _, ref := range refs {
+		key := path.Join("/", ref.Key)
+		if ref.Kind == keyDir || hasGlobMeta(key) || ref.Optional || guarded[key] {
+			continue
+		}
+		if !declared[key] {
+			declared[key] = true
+			added = append(added, key)
+		}
+	}
+	for _, ref := range refs {
+		key := path.Join("/", ref.Key)
+		if ref.Optional && !declared[key] {
+			declared[key] = true
+			optional = append(optional, key)
+		}
+	}
+	for _, ref := range refs {
+		key := path.Join("/", ref.Key)
+		if ref.Kind != keyDir && !hasGlobMeta(key) {
+			continue
+		}
+		if !keyRefResolvable(ref.Kind, key, declared) {
+			unresolved = append(unresolved, ref)
+		}
+	}
+
+	if len(added) > 0 {
+		sort.Strings(added)
+		if len(keys) > 0 {
+			log.Info("模板 %s 引用了未在 keys 中声明的键 %v，已自动加入", t.Src, added)
+		}
+		keys = append(keys, added...)
+	}
+	t.Keys = keys
+	sort.Strings(optional)
+	t.optionalKeys = optional
+	for _, ref := range unresolved {
+		log.Warning(fmt.Sprintf("模板 %s 中 %s %q 无法匹配任何已声明的键", t.Src, ref.Func, ref.Key))
+	}
+}
+
+// keyRefResolvable 判断模式或目录引用是否至少能匹配一个已知键
+func keyRefResolvable(kind keyFuncKind, key string, known map[string]bool) bool {
+	for k := range known {
+		switch kind {
+		case keyDir:
+			if key == "/" || k == key || strings.HasPrefix(k, key+"/") {
+				return true
+			}
+		default:
+			if ok, _ := path.Match(key, k); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
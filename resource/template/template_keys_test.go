@@ -0,0 +1,93 @@
+package template
+
+import (
+	"reflect"
+	"testing"
+	"text/template"
+)
+
+// parseKeyTestTemplate 用占位函数解析模板集合，第一个为入口模板，其余为片段
+func parseKeyTestTemplate(t *testing.T, src string, partials ...string) *template.Template {
+	t.Helper()
+	stub := func(...interface{}) string { return "" }
+	funcs := template.FuncMap{}
+	for name := range keyFuncs {
+		funcs[name] = stub
+	}
+	funcs["include"] = stub
+	tmpl := template.New("main.tmpl").Funcs(funcs)
+	for i, p := range partials {
+		if _, err := tmpl.New("partial" + string(rune('a'+i)) + ".tmpl").Parse(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := tmpl.Parse(src); err != nil {
+		t.Fatal(err)
+	}
+	return tmpl
+}
+
+func TestUpdateKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		partials []string
+		declared []string
+		keys     []string
+		optional []string
+	}{
+		{
+			name: "getv",
+			src:  `{{getv "/a"}}{{"/b" | getv}}`,
+			keys: []string{"/a", "/b"},
+		},
+		{
+			name:     "exists and getv with default are optional",
+			src:      `{{getv "/a"}}{{getv "/b" "x"}}{{exists "/c"}}{{"x" | getv "/d"}}`,
+			keys:     []string{"/a"},
+			optional: []string{"/b", "/c", "/d"},
+		},
+		{
+			name:     "exists guard makes getv optional",
+			src:      `{{if exists "/a"}}{{getv "/a"}}{{end}}{{getv "/b"}}{{getv "/b" "x"}}`,
+			keys:     []string{"/b"},
+			optional: []string{"/a"},
+		},
+		{
+			name:     "declared key is not optional",
+			src:      `{{getv "/a" "x"}}`,
+			declared: []string{"/a"},
+			keys:     []string{"/a"},
+		},
+		{
+			name:     "unused partial is skipped",
+			src:      `{{getv "/a"}}`,
+			partials: []string{`{{define "unused"}}{{getv "/u"}}{{end}}`, `{{getv "/v"}}`},
+			keys:     []string{"/a"},
+		},
+		{
+			name:     "template and include follow partials",
+			src:      `{{template "t1" .}}{{include "partialb.tmpl"}}`,
+			partials: []string{`{{define "t1"}}{{getv "/t"}}{{end}}{{define "t2"}}{{getv "/u"}}{{end}}`, `{{getv "/i"}}`},
+			keys:     []string{"/i", "/t"},
+		},
+		{
+			name:     "dynamic include walks all templates",
+			src:      `{{include .Name}}`,
+			partials: []string{`{{getv "/p"}}`},
+			keys:     []string{"/p"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := &TemplateResource{Src: "main.tmpl", declaredKeys: tt.declared}
+			tr.updateKeys(parseKeyTestTemplate(t, tt.src, tt.partials...))
+			if !reflect.DeepEqual(tr.Keys, tt.keys) && !(len(tr.Keys) == 0 && len(tt.keys) == 0) {
+				t.Errorf("Keys = %v, want %v", tr.Keys, tt.keys)
+			}
+			if !reflect.DeepEqual(tr.optionalKeys, tt.optional) && !(len(tr.optionalKeys) == 0 && len(tt.optional) == 0) {
+				t.Errorf("optionalKeys = %v, want %v", tr.optionalKeys, tt.optional)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package template
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"text/template"
+
+	"github.com/Risingtao/nacos-confd/util"
+)
+
+// partialsDirName 是模板目录下存放共享片段的子目录，其中的 *.tmpl 会被所有资源加载
+const partialsDirName = "_partials"
+
+// maxIncludeDepth 限制 include 的嵌套深度，避免片段互相引用导致无限递归
+const maxIncludeDepth = 100
+
+// partialFiles 返回需要与 Src 一起解析的片段文件：先是 _partials 目录中的 *.tmpl，
+// 再是资源 include 列表中匹配到的文件，重复的路径只保留一次
+func (t *TemplateResource) partialFiles() ([]string, error) {
+	var files []string
+	seen := map[string]bool{t.Src: true}
+	add := func(paths []string) {
+		sort.Strings(paths)
+		for _, p := range paths {
+			if !seen[p] {
+				seen[p] = true
+				files = append(files, p)
+			}
+		}
+	}
+
+	partialsDir := filepath.Join(t.templateDir, partialsDirName)
+	if util.IsFileExist(partialsDir) {
+		paths, err := util.RecursiveFilesLookup(partialsDir, "*.tmpl")
+		if err != nil {
+			return nil, fmt.Errorf("查找模板片段出错: %w", err)
+		}
+		add(paths)
+	}
+
+	for _, inc := range t.Include {
+		pattern := inc
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(t.templateDir, pattern)
+		}
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("无效的 include %s: %w", inc, err)
+		}
+		if len(paths) == 0 {
+			return nil, errors.New("缺少 include 模板: " + inc)
+		}
+		add(paths)
+	}
+	return files, nil
+}
+
+// parseTemplate 解析 Src 以及所有片段文件，返回以 Src 为入口的模板集合。
+// 片段中 {{define}} 的模板可以通过 {{template "name" .}} 或 include 函数引用。
+// 解析结果按各文件的修改时间和大小缓存，文件变化后重新解析并重新分析引用的键。
+func (t *TemplateResource) parseTemplate() (*template.Template, error) {
+	if !util.IsFileExist(t.Src) {
+		return nil, errors.New("缺少模板: " + t.Src)
+	}
+
+	partials, err := t.partialFiles()
+	if err != nil {
+		return nil, err
+	}
+	stamp, err := stampFiles(append([]string{t.Src}, partials...)...)
+	if err != nil {
+		return nil, err
+	}
+	if t.parsed != nil && sameStamps(t.parsedStamp, stamp) {
+		return t.parsed, nil
+	}
+
+	tmpl, err := t.parseTemplateFiles(partials)
+	if err != nil {
+		return nil, err
+	}
+	t.parsed = tmpl
+	t.parsedStamp = stamp
+	t.volatile = isVolatileTemplate(tmpl) || (t.destTmpl != nil && isVolatileTemplate(t.destTmpl))
+	t.updateKeys(tmpl)
+	return tmpl, nil
+}
+
+// parseTemplateFiles 读取并解析 Src 和给定的片段文件
+func (t *TemplateResource) parseTemplateFiles(partials []string) (*template.Template, error) {
+	// 读取模板内容以确保它正确包含所有行
+	templateContent, err := ioutil.ReadFile(t.Src)
+	if err != nil {
+		return nil, fmt.Errorf("无法读取模板 %s, %s", t.Src, err)
+	}
+
+	// 自定义分隔符需在创建片段之前设置，片段会沿用同一组分隔符
+	tmpl := template.New(filepath.Base(t.Src)).Delims(t.LeftDelimiter, t.RightDelimiter)
+	if t.Strict {
+		tmpl.Option("missingkey=error")
+	}
+	depth := 0
+	tmpl.Funcs(t.funcMap).Funcs(map[string]interface{}{
+		"include": func(name string, data ...interface{}) (string, error) {
+			if depth >= maxIncludeDepth {
+				return "", fmt.Errorf("include %s 嵌套超过 %d 层", name, maxIncludeDepth)
+			}
+			depth++
+			defer func() { depth-- }()
+
+			var dot interface{}
+			if len(data) > 0 {
+				dot = data[0]
+			}
+			var buffer bytes.Buffer
+			if err := tmpl.ExecuteTemplate(&buffer, name, dot); err != nil {
+				return "", err
+			}
+			return buffer.String(), nil
+		},
+	})
+
+	for _, p := range partials {
+		content, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("无法读取模板片段 %s, %s", p, err)
+		}
+		if _, err := tmpl.New(filepath.Base(p)).Parse(string(content)); err != nil {
+			return nil, fmt.Errorf("无法处理模板片段 %s, %s", p, err)
+		}
+	}
+
+	if _, err := tmpl.Parse(string(templateContent)); err != nil {
+		return nil, fmt.Errorf("无法处理模板 %s, %s", t.Src, err)
+	}
+	return tmpl, nil
+}
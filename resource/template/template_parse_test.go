@@ -0,0 +1,176 @@
+package template
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+)
+
+// _partials 中的片段对所有资源可用，include 列表中的文件只对声明它的资源可用；
+// 未在 keys 中声明的键通过片段和 include 发现
+func TestPartialsAndInclude(t *testing.T) {
+	e := newTestEnv(t, map[string]string{"/a": "1", "/p": "2", "/f": "3"})
+	e.writeTemplate("_partials/common/header.tmpl", `{{define "header"}}# p={{getv "/p"}}{{end}}`)
+	e.writeTemplate("extra/footer.tmpl", `# f={{getv "/f"}}`)
+	e.writeTemplate("t.tmpl", "{{template \"header\" .}}\na={{getv \"/a\"}}\n{{include \"footer.tmpl\" .}}")
+	e.writeResource("t.toml", "[template]\nsrc = \"t.tmpl\"\ndest = \"{{out}}/t.conf\"\ninclude = [\"extra/*.tmpl\"]\n")
+	tr := e.resource("t.toml")
+	if err := tr.process(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := e.readOut("t.conf"), "# p=2\na=1\n# f=3"; got != want {
+		t.Errorf("t.conf = %q, want %q", got, want)
+	}
+	if want := []string{"/a", "/f", "/p"}; !reflect.DeepEqual(tr.Keys, want) {
+		t.Errorf("keys = %v, want %v", tr.Keys, want)
+	}
+
+	// 没有声明 include 的资源不能使用 extra 中的文件
+	e.writeResource("u.toml", "[template]\nsrc = \"t.tmpl\"\ndest = \"{{out}}/u.conf\"\n")
+	if err := e.resource("u.toml").process(); err == nil || !strings.Contains(err.Error(), "footer.tmpl") {
+		t.Errorf("process without include = %v, want footer.tmpl to be undefined", err)
+	}
+
+	e.writeResource("v.toml", "[template]\nsrc = \"t.tmpl\"\ndest = \"{{out}}/v.conf\"\ninclude = [\"missing/*.tmpl\"]\n")
+	if err := e.resource("v.toml").process(); err == nil || !strings.Contains(err.Error(), "缺少 include 模板: missing/*.tmpl") {
+		t.Errorf("process with missing include = %v", err)
+	}
+}
+
+func TestIncludeDepthLimit(t *testing.T) {
+	e := newTestEnv(t, nil)
+	// 恰好嵌套 maxIncludeDepth 层时正常结束
+	e.writeTemplate("_partials/count.tmpl", `{{define "count"}}{{if lt . 100}}{{include "count" (add . 1)}}{{else}}{{.}}{{end}}{{end}}`)
+	e.writeTemplate("ok.tmpl", `{{include "count" 1}}`)
+	e.writeResource("ok.toml", "[template]\nsrc = \"ok.tmpl\"\ndest = \"{{out}}/ok.conf\"\n")
+	if err := e.resource("ok.toml").process(); err != nil {
+		t.Fatal(err)
+	}
+	if got := e.readOut("ok.conf"); got != "100" {
+		t.Errorf("ok.conf = %q, want 100", got)
+	}
+
+	// 互相引用的片段在超过限制时报错，而不是无限递归
+	e.writeTemplate("_partials/loop.tmpl", `{{define "ping"}}{{include "pong"}}{{end}}{{define "pong"}}{{include "ping"}}{{end}}`)
+	e.writeTemplate("loop.tmpl", `{{include "ping"}}`)
+	e.writeResource("loop.toml", "[template]\nsrc = \"loop.tmpl\"\ndest = \"{{out}}/loop.conf\"\n")
+	err := e.resource("loop.toml").process()
+	if err == nil || !strings.Contains(err.Error(), "嵌套超过 100 层") {
+		t.Fatalf("process = %v, want the include depth error", err)
+	}
+	if got := e.readOut("loop.conf"); got != "" {
+		t.Errorf("loop.conf = %q, want it not written", got)
+	}
+}
+
+// 自定义分隔符同样用于片段，默认的 {{ }} 原样输出；片段中的键同样能被发现
+func TestCustomDelimitersInPartials(t *testing.T) {
+	e := newTestEnv(t, map[string]string{"/a": "1"})
+	e.writeTemplate("_partials/p.tmpl", `[[define "p"]]a=[[getv "/a"]] {{.}}[[end]]`)
+	e.writeTemplate("t.tmpl", `[[template "p" .]] [[include "p" .]] {{getv "/a"}}`)
+	e.writeResource("t.toml", "[template]\nsrc = \"t.tmpl\"\ndest = \"{{out}}/t.conf\"\nleft_delimiter = \"[[\"\nright_delimiter = \"]]\"\n")
+	tr := e.resource("t.toml")
+	if err := tr.process(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := e.readOut("t.conf"), `a=1 {{.}} a=1 {{.}} {{getv "/a"}}`; got != want {
+		t.Errorf("t.conf = %q, want %q", got, want)
+	}
+	if want := []string{"/a"}; !reflect.DeepEqual(tr.Keys, want) {
+		t.Errorf("keys = %v, want %v", tr.Keys, want)
+	}
+}
+
+// 解析结果按模板和片段的修改时间与大小缓存，任一文件变化或片段增减后重新解析并更新键
+func TestParseTemplateCache(t *testing.T) {
+	e := newTestEnv(t, nil)
+	src := e.writeTemplate("t.tmpl", `{{getv "/a"}}`)
+	e.writeResource("t.toml", "[template]\nsrc = \"t.tmpl\"\ndest = \"{{out}}/t.conf\"\n")
+	tr := e.resource("t.toml")
+	parse := func() *template.Template {
+		t.Helper()
+		tmpl, err := tr.parseTemplate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tmpl
+	}
+	// 修改时间明确不同，不依赖文件系统的时间精度
+	touch := func(p string, sec int64) {
+		t.Helper()
+		mtime := time.Unix(sec, 0)
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	touch(src, 1000)
+
+	first := parse()
+	if parse() != first {
+		t.Fatal("unchanged template was parsed again")
+	}
+
+	// 大小相同、只有修改时间不同
+	e.writeTemplate("t.tmpl", `{{getv "/b"}}`)
+	touch(src, 2000)
+	second := parse()
+	if second == first {
+		t.Fatal("template with a new mtime was not parsed again")
+	}
+	if want := []string{"/b"}; !reflect.DeepEqual(tr.Keys, want) {
+		t.Errorf("keys = %v, want %v", tr.Keys, want)
+	}
+
+	// 新增片段
+	partial := e.writeTemplate("_partials/p.tmpl", `{{define "p"}}{{getv "/p"}}{{end}}`)
+	touch(partial, 1000)
+	e.writeTemplate("t.tmpl", `{{getv "/b"}}{{template "p"}}`)
+	touch(src, 3000)
+	third := parse()
+	if third == second {
+		t.Fatal("template was not parsed again after a partial was added")
+	}
+	if want := []string{"/b", "/p"}; !reflect.DeepEqual(tr.Keys, want) {
+		t.Errorf("keys = %v, want %v", tr.Keys, want)
+	}
+
+	// 只修改片段
+	e.writeTemplate("_partials/p.tmpl", `{{define "p"}}{{getv "/q"}}{{end}}`)
+	touch(partial, 2000)
+	if parse() == third {
+		t.Fatal("template was not parsed again after a partial changed")
+	}
+	if want := []string{"/b", "/q"}; !reflect.DeepEqual(tr.Keys, want) {
+		t.Errorf("keys = %v, want %v", tr.Keys, want)
+	}
+}
+
+func BenchmarkParseTemplate(b *testing.B) {
+	b.Run("cache hit", func(b *testing.B) {
+		_, t := benchResource(b)
+		if _, err := t.parseTemplate(); err != nil {
+			b.Fatal(err)
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := t.parseTemplate(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("cache miss", func(b *testing.B) {
+		_, t := benchResource(b)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			t.parsed = nil
+			if _, err := t.parseTemplate(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
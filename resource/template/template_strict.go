@@ -0,0 +1,91 @@
+package template
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// noValue 是 text/template 渲染缺失值时输出的占位符
+var noValue = []byte("<no value>")
+
+// addStrictFuncs 在严格模式下替换会静默吞掉错误的模板函数：
+// 查询 DNS 失败时返回错误，getv 的键不存在时即使提供了默认值也返回错误
+func addStrictFuncs(tr *TemplateResource) {
+	addFuncs(tr.funcMap, map[string]interface{}{
+		"getv": func(key string, v ...string) (string, error) {
+			value, err := tr.store.GetValue(key)
+			if err != nil {
+				return "", fmt.Errorf("严格模式: 键 %s 不存在: %w", key, err)
+			}
+			return value, nil
+		},
+		"lookupIP":   StrictLookupIP,
+		"lookupIPV4": StrictLookupIPV4,
+		"lookupIPV6": StrictLookupIPV6,
+		"lookupSRV":  StrictLookupSRV,
+	})
+}
+
+// checkStrictOutput 拒绝包含 <no value> 的渲染结果，并指出所在行号
+func checkStrictOutput(src string, output []byte) error {
+	i := bytes.Index(output, noValue)
+	if i < 0 {
+		return nil
+	}
+	line := bytes.Count(output[:i], []byte("\n")) + 1
+	return fmt.Errorf("严格模式: 模板 %s 的渲染结果第 %d 行包含 %s", src, line, noValue)
+}
+
+// StrictLookupIP 与 LookupIP 相同，但解析失败或没有结果时返回错误
+func StrictLookupIP(data string) ([]string, error) {
+	ips, err := net.LookupIP(data)
+	if err != nil {
+		return nil, fmt.Errorf("解析 %s 失败: %w", data, err)
+	}
+	ipStrings := make([]string, len(ips))
+	for i, ip := range ips {
+		ipStrings[i] = ip.String()
+	}
+	sort.Strings(ipStrings)
+	return ipStrings, nil
+}
+
+// StrictLookupIPV4 与 LookupIPV4 相同，但没有 IPv4 地址时返回错误
+func StrictLookupIPV4(data string) ([]string, error) {
+	return strictFilterIPs(data, ".")
+}
+
+// StrictLookupIPV6 与 LookupIPV6 相同，但没有 IPv6 地址时返回错误
+func StrictLookupIPV6(data string) ([]string, error) {
+	return strictFilterIPs(data, ":")
+}
+
+func strictFilterIPs(data, sep string) ([]string, error) {
+	ips, err := StrictLookupIP(data)
+	if err != nil {
+		return nil, err
+	}
+	var addresses []string
+	for _, ip := range ips {
+		if strings.Contains(ip, sep) {
+			addresses = append(addresses, ip)
+		}
+	}
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("%s 没有匹配的地址", data)
+	}
+	return addresses, nil
+}
+
+// StrictLookupSRV 与 LookupSRV 相同，但查询失败时返回错误
+func StrictLookupSRV(service, proto, name string) ([]*net.SRV, error) {
+	_, addrs, err := net.LookupSRV(service, proto, name)
+	if err != nil {
+		return nil, fmt.Errorf("查询 SRV 记录 _%s._%s.%s 失败: %w", service, proto, name, err)
+	}
+	sort.Sort(sortSRV(addrs))
+	return addrs, nil
+}
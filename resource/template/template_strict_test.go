@@ -0,0 +1,60 @@
+package template
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStrictRendering(t *testing.T) {
+	tests := []struct {
+		name    string
+		tmpl    string
+		strict  bool
+		want    string
+		wantErr string
+	}{
+		{name: "getv default", tmpl: `{{getv "/missing" "d"}}`, want: "d"},
+		{name: "strict getv default", tmpl: `{{getv "/missing" "d"}}`, strict: true, wantErr: "/missing"},
+		{name: "strict getv present", tmpl: `{{getv "/a" "d"}}`, strict: true, want: "1"},
+		{name: "strict exists guard", tmpl: `{{if exists "/missing"}}{{getv "/missing"}}{{else}}none{{end}}`, strict: true, want: "none"},
+		{name: "no value", tmpl: `{{.Values.nope}}`, want: "<no value>"},
+		{name: "strict missing map key", tmpl: `{{.Values.nope}}`, strict: true, wantErr: "nope"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEnv(t, map[string]string{"/a": "1"})
+			e.writeTemplate("t.tmpl", tt.tmpl)
+			strict := ""
+			if tt.strict {
+				strict = "strict = true\n"
+			}
+			e.writeResource("t.toml", "[template]\nsrc = \"t.tmpl\"\ndest = \"{{out}}/t.conf\"\nkeys = [\"/a\"]\n"+strict)
+			err := e.resource("t.toml").process()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want error containing %q", err, tt.wantErr)
+				}
+				if got := e.outFiles(); len(got) != 0 {
+					t.Errorf("out = %v, want nothing written", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := e.readOut("t.conf"); got != tt.want {
+				t.Errorf("t.conf = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckStrictOutput(t *testing.T) {
+	if err := checkStrictOutput("x", []byte("a\nb\n")); err != nil {
+		t.Errorf("clean output: %v", err)
+	}
+	err := checkStrictOutput("x", []byte("a\nb=<no value>\n"))
+	if err == nil || !strings.Contains(err.Error(), "第 2 行") {
+		t.Errorf("error = %v, want line 2", err)
+	}
+}
@@ -0,0 +1,318 @@
+package template
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Risingtao/nacos-confd/depends/toml"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// validators 是 validate 选项可用的内置校验器，按名称索引
+var validators = map[string]func([]byte) error{
+	"json":              validateJSON,
+	"yaml":              validateYAML,
+	"toml":              validateTOML,
+	"xml":               validateXML,
+	"ini":               validateINI,
+	"nginx-syntax-lite": validateNginxLite,
+}
+
+// ValidationError 描述暂存文件未通过内置校验的位置，Line 和 Column 从 1 开始，
+// 为 0 表示校验器无法给出该信息
+type ValidationError struct {
+	Format string
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *ValidationError) Error() string {
+	switch {
+	case e.Line > 0 && e.Column > 0:
+		return fmt.Sprintf("%s 格式校验失败: 第 %d 行第 %d 列: %s", e.Format, e.Line, e.Column, e.Msg)
+	case e.Line > 0:
+		return fmt.Sprintf("%s 格式校验失败: 第 %d 行: %s", e.Format, e.Line, e.Msg)
+	}
+	return fmt.Sprintf("%s 格式校验失败: %s", e.Format, e.Msg)
+}
+
+// checkValidator 在加载资源时确认 validate 选项是已知的校验器
+func checkValidator(name string) error {
+	if name == "" {
+		return nil
+	}
+	if _, ok := validators[name]; ok {
+		return nil
+	}
+	names := make([]string, 0, len(validators))
+	for n := range validators {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("未知的 validate 校验器 %q，可选值: %s", name, strings.Join(names, ", "))
+}
+
+// validate 使用资源配置的内置校验器检查暂存文件
+func (t *TemplateResource) validate() error {
+	if t.Validate == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(t.StageFile.Name())
+	if err != nil {
+		return fmt.Errorf("读取暂存文件失败: %v", err)
+	}
+	return validators[t.Validate](data)
+}
+
+// lineColumn 将字节偏移量换算为从 1 开始的行号和列号（列按 rune 计算）
+func lineColumn(data []byte, offset int64) (int, int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	start := bytes.LastIndexByte(before, '\n') + 1
+	return line, len([]rune(string(before[start:]))) + 1
+}
+
+// failedAt 返回解码器在读取 offset 个字节后报错时出错字节的偏移量
+func failedAt(data []byte, offset int64) int64 {
+	if offset > 0 && offset <= int64(len(data)) {
+		return offset - 1
+	}
+	return offset
+}
+
+func validateJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	var v interface{}
+	err := dec.Decode(&v)
+	if err == nil {
+		offset := dec.InputOffset()
+		if _, err = dec.Token(); err == io.EOF {
+			return nil
+		}
+		// 指向多余内容的开头
+		for offset < int64(len(data)) && strings.IndexByte(" \t\r\n", data[offset]) >= 0 {
+			offset++
+		}
+		line, col := lineColumn(data, offset)
+		return &ValidationError{Format: "json", Line: line, Column: col, Msg: "JSON 值之后存在多余内容"}
+	}
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		line, col := lineColumn(data, failedAt(data, syntaxErr.Offset))
+		return &ValidationError{Format: "json", Line: line, Column: col, Msg: syntaxErr.Error()}
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		line, col := lineColumn(data, int64(len(data)))
+		return &ValidationError{Format: "json", Line: line, Column: col, Msg: "意外的文件结尾"}
+	}
+	return &ValidationError{Format: "json", Msg: err.Error()}
+}
+
+var yamlLineRe = regexp.MustCompile(`line (\d+)(?:, column (\d+))?: (.*)`)
+
+func validateYAML(data []byte) error {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var node yaml.Node
+		err := dec.Decode(&node)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			verr := &ValidationError{Format: "yaml", Msg: strings.TrimPrefix(err.Error(), "yaml: ")}
+			if m := yamlLineRe.FindStringSubmatch(err.Error()); m != nil {
+				verr.Line, _ = strconv.Atoi(m[1])
+				verr.Column, _ = strconv.Atoi(m[2])
+				verr.Msg = m[3]
+			}
+			return verr
+		}
+	}
+}
+
+var tomlLineRe = regexp.MustCompile(`^Near line (\d+) \(last key parsed '.*?'\): (.*)$`)
+
+func validateTOML(data []byte) error {
+	var v map[string]interface{}
+	_, err := toml.Decode(string(data), &v)
+	if err == nil {
+		return nil
+	}
+	verr := &ValidationError{Format: "toml", Msg: err.Error()}
+	if m := tomlLineRe.FindStringSubmatch(err.Error()); m != nil {
+		verr.Line, _ = strconv.Atoi(m[1])
+		verr.Msg = m[2]
+	}
+	return verr
+}
+
+func validateXML(data []byte) error {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = true
+	roots := 0
+	depth := 0
+	for {
+		offset := dec.InputOffset()
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			line, col := lineColumn(data, dec.InputOffset())
+			var syntaxErr *xml.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				if syntaxErr.Msg != "unexpected EOF" {
+					line, col = lineColumn(data, failedAt(data, dec.InputOffset()))
+				}
+				return &ValidationError{Format: "xml", Line: line, Column: col, Msg: syntaxErr.Msg}
+			}
+			return &ValidationError{Format: "xml", Line: line, Column: col, Msg: err.Error()}
+		}
+		switch tok.(type) {
+		case xml.StartElement:
+			if depth == 0 {
+				roots++
+				if roots > 1 {
+					line, col := lineColumn(data, offset)
+					return &ValidationError{Format: "xml", Line: line, Column: col, Msg: "存在多个根元素"}
+				}
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+		}
+	}
+	if roots == 0 {
+		return &ValidationError{Format: "xml", Msg: "缺少根元素"}
+	}
+	return nil
+}
+
+var iniLineRe = regexp.MustCompile(`^第 (\d+) 行: (.*)$`)
+
+func validateINI(data []byte) error {
+	_, err := FromIni(string(data))
+	if err == nil {
+		return nil
+	}
+	verr := &ValidationError{Format: "ini", Msg: err.Error()}
+	if m := iniLineRe.FindStringSubmatch(err.Error()); m != nil {
+		verr.Line, _ = strconv.Atoi(m[1])
+		verr.Column = 1
+		verr.Msg = m[2]
+	}
+	return verr
+}
+
+// validateNginxLite 对 nginx 配置做轻量的结构检查：花括号配对、引号闭合、
+// 指令以 ; 结尾。它不了解具体指令的语义，无法替代 nginx -t。
+func validateNginxLite(data []byte) error {
+	type pos struct{ line, col int }
+	fail := func(p pos, msg string) error {
+		return &ValidationError{Format: "nginx-syntax-lite", Line: p.line, Column: p.col, Msg: msg}
+	}
+
+	runes := []rune(string(data))
+	cur := pos{1, 1}
+	var blocks []pos // 未闭合的 { 的位置
+	var stmt *pos    // 当前未结束指令的起始位置
+	advance := func(r rune) {
+		if r == '\n' {
+			cur.line++
+			cur.col = 1
+		} else {
+			cur.col++
+		}
+	}
+	startStmt := func() {
+		if stmt == nil {
+			p := cur
+			stmt = &p
+		}
+	}
+
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '#':
+			for i < len(runes) && runes[i] != '\n' {
+				advance(runes[i])
+				i++
+			}
+			if i < len(runes) {
+				advance(runes[i])
+			}
+			continue
+		case r == '"' || r == '\'':
+			startStmt()
+			open := cur
+			quote := r
+			advance(r)
+			i++
+			for ; i < len(runes) && runes[i] != quote; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					advance(runes[i])
+					i++
+				}
+				advance(runes[i])
+			}
+			if i >= len(runes) {
+				return fail(open, "引号未闭合")
+			}
+		case r == '$' && i+1 < len(runes) && runes[i+1] == '{':
+			// ${var} 形式的变量，其中的花括号不是块
+			startStmt()
+			for i < len(runes) && runes[i] != '}' {
+				advance(runes[i])
+				i++
+			}
+			if i >= len(runes) {
+				return fail(*stmt, "变量的 ${ 未闭合")
+			}
+		case r == '{':
+			if stmt == nil {
+				return fail(cur, "块之前缺少指令名")
+			}
+			blocks = append(blocks, cur)
+			stmt = nil
+		case r == '}':
+			if stmt != nil {
+				return fail(*stmt, "指令缺少结尾的 ;")
+			}
+			if len(blocks) == 0 {
+				return fail(cur, "多余的 }")
+			}
+			blocks = blocks[:len(blocks)-1]
+		case r == ';':
+			if stmt == nil {
+				return fail(cur, "空指令")
+			}
+			stmt = nil
+		case r == ' ' || r == '\t' || r == '\r' || r == '\n':
+		default:
+			startStmt()
+		}
+		advance(r)
+	}
+
+	if stmt != nil {
+		return fail(*stmt, "指令缺少结尾的 ;")
+	}
+	if len(blocks) > 0 {
+		return fail(blocks[len(blocks)-1], "块未闭合，缺少 }")
+	}
+	return nil
+}
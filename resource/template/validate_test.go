@@ -0,0 +1,112 @@
+package template
+
+import (
+	"strings"
+	"testing"
+)
+
+// validateCase 是一个校验器的测试用例，wantMsg 为空表示输入应通过校验
+type validateCase struct {
+	name    string
+	input   string
+	line    int
+	column  int
+	wantMsg string
+}
+
+func runValidateCases(t *testing.T, validate func([]byte) error, tests []validateCase) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validate([]byte(tt.input))
+			if tt.wantMsg == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			verr, ok := err.(*ValidationError)
+			if !ok {
+				t.Fatalf("error = %#v, want *ValidationError", err)
+			}
+			if verr.Line != tt.line || verr.Column != tt.column {
+				t.Errorf("position = %d:%d, want %d:%d (%v)", verr.Line, verr.Column, tt.line, tt.column, verr)
+			}
+			if !strings.Contains(verr.Msg, tt.wantMsg) {
+				t.Errorf("message = %q, want it to contain %q", verr.Msg, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestValidateJSON(t *testing.T) {
+	runValidateCases(t, validateJSON, []validateCase{
+		{name: "object", input: "{\"a\": [1, 2], \"b\": {\"c\": null}}\n"},
+		{name: "trailing comma", input: "{\n  \"a\": 1,\n}", line: 3, column: 1, wantMsg: "invalid character '}'"},
+		{name: "bad literal", input: "[1,\n tru]", line: 2, column: 5, wantMsg: "invalid character ']'"},
+		{name: "unexpected end", input: "{\"a\": ", line: 1, column: 7, wantMsg: "意外的文件结尾"},
+		{name: "two values", input: "{}\n  {}", line: 2, column: 3, wantMsg: "多余内容"},
+		{name: "column counts runes", input: "{\"名字\": x}", line: 1, column: 8, wantMsg: "invalid character 'x'"},
+	})
+}
+
+func TestValidateYAML(t *testing.T) {
+	runValidateCases(t, validateYAML, []validateCase{
+		{name: "mapping", input: "a: 1\nb: [x, y]\n"},
+		{name: "multiple documents", input: "a: 1\n---\nb: 2\n"},
+		{name: "bad indentation", input: "a: 1\n b: 2\n", line: 2, wantMsg: "mapping values are not allowed"},
+		{name: "tab", input: "a:\n\tb: 1\n", line: 2, wantMsg: "found character that cannot start any token"},
+		{name: "unclosed flow sequence", input: "a: [1, 2\nb: 3\n", line: 1, wantMsg: "did not find expected ',' or ']'"},
+		{name: "error in second document", input: "a: 1\n---\nb: \"x\n", line: 3, wantMsg: "found unexpected end of stream"},
+	})
+}
+
+func TestValidateTOML(t *testing.T) {
+	runValidateCases(t, validateTOML, []validateCase{
+		{name: "tables", input: "a = 1\n[t]\nb = \"x\"\n"},
+		{name: "missing value", input: "a = 1\nb = \n", line: 2, wantMsg: "expected value"},
+		{name: "duplicate key", input: "a = 1\n[t]\nb = 1\nb = 2\n", line: 4, wantMsg: "b"},
+		{name: "unclosed string", input: "a = \"x\nb = 1\n", line: 1, wantMsg: "string"},
+	})
+}
+
+func TestValidateXML(t *testing.T) {
+	runValidateCases(t, validateXML, []validateCase{
+		{name: "document", input: "<?xml version=\"1.0\"?>\n<a x=\"1\"><b/>text</a>\n"},
+		{name: "mismatched end tag", input: "<a>\n  <b></a>", line: 2, column: 9, wantMsg: "element <b> closed by </a>"},
+		{name: "unclosed root", input: "<a>\n<b/>\n", line: 3, column: 1, wantMsg: "unexpected EOF"},
+		{name: "two roots", input: "<a/>\n<b/>", line: 2, column: 1, wantMsg: "多个根元素"},
+		{name: "no root", input: "<?xml version=\"1.0\"?>\n", wantMsg: "缺少根元素"},
+		{name: "bad attribute", input: "<a x=1/>", line: 1, column: 6, wantMsg: "unquoted or missing attribute value"},
+	})
+}
+
+func TestValidateINI(t *testing.T) {
+	runValidateCases(t, validateINI, []validateCase{
+		{name: "sections", input: "; comment\ntop = 1\n[s]\nk = v\n"},
+		{name: "line without separator", input: "[s]\nk = v\noops\n", line: 3, column: 1, wantMsg: "缺少 = 或 :"},
+		{name: "unclosed section", input: "k = v\n[s\n", line: 2, column: 1, wantMsg: "节名缺少 ]"},
+	})
+}
+
+func TestValidateNginxLite(t *testing.T) {
+	runValidateCases(t, validateNginxLite, []validateCase{
+		{name: "blocks", input: "events {}\nhttp {\n  server { listen 80; }\n}\n"},
+		{name: "braces in quotes", input: "location / { return 200 \"{ok}\"; add_header X '}'; }\n"},
+		{name: "escaped quote", input: "add_header X \"a\\\"b{\";\n"},
+		{name: "variable braces", input: "return 200 ${host}x;\n"},
+		{name: "braces in comment", input: "# } {\nhttp { } # {\n"},
+		{name: "unclosed block", input: "http {\n  server {\n  }\n", line: 1, column: 6, wantMsg: "缺少 }"},
+		{name: "extra closing brace", input: "http {\n}\n}\n", line: 3, column: 1, wantMsg: "多余的 }"},
+		{name: "missing semicolon before }", input: "http {\n  listen 80\n}\n", line: 2, column: 3, wantMsg: "缺少结尾的 ;"},
+		{name: "missing semicolon at end", input: "worker_processes 1\n", line: 1, column: 1, wantMsg: "缺少结尾的 ;"},
+		{name: "unclosed double quote", input: "http {\n  return 200 \"abc;\n}\n", line: 2, column: 14, wantMsg: "引号未闭合"},
+		{name: "unclosed single quote", input: "a 'b;", line: 1, column: 3, wantMsg: "引号未闭合"},
+		{name: "block without name", input: "http {\n  {\n  }\n}\n", line: 2, column: 3, wantMsg: "缺少指令名"},
+		{name: "empty directive", input: "http { ; }", line: 1, column: 8, wantMsg: "空指令"},
+		{name: "unclosed variable", input: "return ${host;\n", line: 1, column: 1, wantMsg: "${ 未闭合"},
+		{name: "column counts runes", input: "# 注释\nserver_name 例子.com\n", line: 2, column: 1, wantMsg: "缺少结尾的 ;"},
+		{name: "column after multibyte text", input: "a \"例子\" }", line: 1, column: 1, wantMsg: "缺少结尾的 ;"},
+		{name: "brace after multibyte text", input: "a \"例子\";}", line: 1, column: 8, wantMsg: "多余的 }"},
+	})
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+var (
+	// reloadSignal 让 confd 重新读取配置文件并重启处理器
+	reloadSignal os.Signal = syscall.SIGHUP
+	// resyncSignal 让 confd 立即处理所有模板资源
+	resyncSignal os.Signal = syscall.SIGUSR1
+	// notifySignals 是 confd 处理的全部信号
+	notifySignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR1}
+)
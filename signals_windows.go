@@ -0,0 +1,16 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+var (
+	// Windows 上没有 SIGHUP 和 SIGUSR1，重新加载配置和立即处理都不可用
+	reloadSignal  os.Signal
+	resyncSignal  os.Signal
+	notifySignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+)